@@ -1,67 +1,65 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
+	"crypto/tls"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
+	"time"
 
-	"github.com/nordluma/httpfromtcp/internal/headers"
+	"github.com/nordluma/httpfromtcp/internal/fileserver"
+	"github.com/nordluma/httpfromtcp/internal/middleware"
 	"github.com/nordluma/httpfromtcp/internal/request"
 	"github.com/nordluma/httpfromtcp/internal/response"
+	"github.com/nordluma/httpfromtcp/internal/router"
 	"github.com/nordluma/httpfromtcp/internal/server"
+	"github.com/nordluma/httpfromtcp/internal/vhost"
 )
 
 const port = 42069
 
-func defaultHandler(w *response.Writer, req *request.Request) {
-	target := req.RequestLine.RequestTarget
-	if strings.HasPrefix(target, "/httpbin/") {
-		proxyHandler(w, req)
-		return
-	}
-
-	if target == "/video" {
-		videoHandler(w, req)
-		return
-	}
-
-	if target == "/yourproblem" {
-		handler400(w, req)
-		return
-	}
-
-	if target == "/myproblem" {
-		handler500(w, req)
-		return
+// shutdownTimeout is how long in-flight requests get to finish on SIGTERM.
+const shutdownTimeout = 10 * time.Second
+
+// newRouter registers the demo routes. The /video and /assets routes are
+// left out when assets is nil.
+func newRouter(assets *fileserver.FileServer) *router.Router {
+	rt := router.New()
+	rt.Get("/", handler200)
+	rt.Get("/httpbin/*", proxyHandler)
+	if assets != nil {
+		rt.Get("/video", func(w *response.Writer, req *request.Request) {
+			assets.ServeFile(w, req, "vim.mp4")
+		})
+		rt.Get("/assets/*", assets.Serve)
 	}
+	rt.Handle("", "/yourproblem", handler400)
+	rt.Handle("", "/myproblem", handler500)
 
-	handler200(w, req)
+	return rt
 }
 
-func videoHandler(w *response.Writer, req *request.Request) {
-	videoBytes, err := os.ReadFile("./assets/vim.mp4")
-	if err != nil {
-		fmt.Printf("error reading video: %v", err)
-		handler500(w, req)
-		return
-	}
+// newSites maps the hosts served by this process to their handlers. Requests
+// for any other host get the demo routes.
+func newSites(assets *fileserver.FileServer) *vhost.Dispatcher {
+	sites := vhost.New()
+	sites.Default = newRouter(assets).Serve
 
-	w.WriteStatusLine(response.Ok)
-	h := response.GetDefaultHeaders(len(videoBytes))
-	h.Override("content-type", "video/mp4")
-	w.WriteHeaders(h)
-	w.WriteBody(videoBytes)
+	return sites
 }
 
 func proxyHandler(w *response.Writer, req *request.Request) {
-	target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-	url := "https://httpbin.org/" + target
+	url := "https://httpbin.org/" + req.PathValue("*")
+	if req.Target.RawQuery != "" {
+		url += "?" + req.Target.RawQuery
+	}
 	fmt.Printf("Proxying to: %s\n", url)
 	res, err := http.Get(url)
 	if err != nil {
@@ -70,12 +68,8 @@ func proxyHandler(w *response.Writer, req *request.Request) {
 	}
 	defer res.Body.Close()
 
-	w.WriteStatusLine(response.Ok)
-	h := response.GetDefaultHeaders(0)
-	h.Override("Transfer-Encoding", "chunked")
-	h.Override("Trailer", "X-Content-SHA256, X-Content-Length")
-	h.Delete("content-length")
-	w.WriteHeaders(h)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Trailer", "X-Content-SHA256, X-Content-Length")
 
 	const chunkSize = 1024
 	fullBody := make([]byte, 0)
@@ -84,11 +78,13 @@ func proxyHandler(w *response.Writer, req *request.Request) {
 		n, err := res.Body.Read(buf)
 		fmt.Printf("read %d bytes\n", n)
 		if n > 0 {
-			if _, err = w.WriteChunkedBody(buf[:n]); err != nil {
+			if _, err = w.Write(buf[:n]); err != nil {
 				fmt.Printf("error writing chunk: %v\n", err)
 				break
 			}
 
+			// pass every part on as soon as it arrives
+			w.Flush()
 			fullBody = append(fullBody, buf[:n]...)
 		}
 
@@ -102,20 +98,9 @@ func proxyHandler(w *response.Writer, req *request.Request) {
 		}
 	}
 
-	if _, err = w.WriteChunkedBodyDone(); err != nil {
-		fmt.Printf("error writing chunked body done: %v\n", err)
-	}
-
-	trailers := headers.NewHeaders()
 	sha256 := fmt.Sprintf("%x", sha256.Sum256(fullBody))
-	trailers.Override("X-Content-SHA256", sha256)
-	trailers.Override("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
-
-	if err = w.WriteTrailers(trailers); err != nil {
-		fmt.Printf("error writing trailers: %v", err)
-	}
-
-	fmt.Println("trailers written")
+	w.Trailers().Set("X-Content-SHA256", sha256)
+	w.Trailers().Set("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
 }
 
 func handler400(w *response.Writer, _ *request.Request) {
@@ -130,10 +115,8 @@ func handler400(w *response.Writer, _ *request.Request) {
   </body>
 </html>`)
 
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
+	w.Header().Set("Content-Type", "text/html")
+	w.Write(body)
 }
 
 func handler500(w *response.Writer, _ *request.Request) {
@@ -148,10 +131,8 @@ func handler500(w *response.Writer, _ *request.Request) {
   </body>
 </html>`)
 
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
+	w.Header().Set("Content-Type", "text/html")
+	w.Write(body)
 }
 
 func handler200(w *response.Writer, _ *request.Request) {
@@ -166,22 +147,89 @@ func handler200(w *response.Writer, _ *request.Request) {
   </body>
 </html>`)
 
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
+	w.Header().Set("Content-Type", "text/html")
+	w.Write(body)
+}
+
+func startServer(
+	handler server.Handler,
+	opts []server.Option,
+) (*server.Server, error) {
+	if *certFile != "" {
+		return server.ServeTLS(port, *certFile, *keyFile, handler, opts...)
+	}
+
+	return server.Serve(port, handler, opts...)
 }
 
+var (
+	certFile   = flag.String("cert", "", "PEM certificate to serve HTTPS with")
+	keyFile    = flag.String("key", "", "PEM private key of -cert")
+	assetsDir  = flag.String("assets", "./assets", "directory served under /assets")
+	selfSigned = flag.Bool(
+		"self-signed",
+		false,
+		"serve HTTPS with a generated self-signed certificate for localhost",
+	)
+)
+
 func main() {
-	server, err := server.Serve(port, defaultHandler)
+	flag.Parse()
+
+	assets, err := fileserver.New(*assetsDir)
+	if err != nil {
+		log.Printf("Not serving /video and /assets: %v\n", err)
+		assets = nil
+	} else {
+		defer assets.Close()
+		assets.Listing = true
+	}
+
+	handler := middleware.Chain(
+		newSites(assets).Serve,
+		middleware.RequestID,
+		middleware.Logger(log.Default()),
+		middleware.Recover,
+		middleware.Compress,
+	)
+	opts := []server.Option{server.WithConfig(server.Config{
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadBodyTimeout:   30 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+		MaxBodyBytes:      32 << 20,
+
+		DecodeRequestBodies: true,
+		MaxDecodedBodyBytes: 64 << 20,
+	})}
+
+	if *selfSigned {
+		cert, err := server.GenerateSelfSignedCert("localhost", "127.0.0.1", "::1")
+		if err != nil {
+			log.Fatalf("Error generating certificate: %v\n", err)
+		}
+
+		opts = append(opts, server.WithTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}))
+	}
+
+	server, err := startServer(handler, opts)
 	if err != nil {
 		log.Fatalf("Error starting server: %v\n", err)
 	}
-	defer server.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	forced, err := server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Server stopped, %d connections cut off: %v\n", forced, err)
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
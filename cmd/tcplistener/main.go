@@ -36,7 +36,7 @@ func main() {
 			req.RequestLine.HttpVersion,
 		)
 		fmt.Println("Headers:")
-		for key, val := range req.Headers {
+		for key, val := range req.Headers.All() {
 			fmt.Printf("- %s: %s\n", key, val)
 		}
 		fmt.Println("Body:")
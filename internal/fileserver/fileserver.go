@@ -0,0 +1,259 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nordluma/httpfromtcp/internal/headers"
+	"github.com/nordluma/httpfromtcp/internal/request"
+	"github.com/nordluma/httpfromtcp/internal/response"
+)
+
+// extraTypes covers extensions missing from the table built into the mime
+// package on systems without a mime.types file.
+var extraTypes = map[string]string{
+	".ico":  "image/x-icon",
+	".mp4":  "video/mp4",
+	".txt":  "text/plain; charset=utf-8",
+	".webm": "video/webm",
+}
+
+// FileServer serves the files below a directory. Paths are resolved with
+// os.Root, so neither ".." segments nor symbolic links can reach outside of
+// it.
+type FileServer struct {
+	root *os.Root
+
+	// Index serves the index.html of a directory in place of the
+	// directory itself.
+	Index bool
+	// Listing answers requests for directories without an index.html
+	// with a list of their entries. Without it they are not found.
+	Listing bool
+}
+
+// New returns a FileServer rooted at dir with index.html files enabled.
+func New(dir string) (*FileServer, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileServer{root: root, Index: true}, nil
+}
+
+// Close releases the root directory.
+func (s *FileServer) Close() error {
+	return s.root.Close()
+}
+
+// Serve is a server.Handler serving the file named by the part of the path
+// matched by the "*" of a router pattern, e.g. "/static/*". Directories are
+// requested with a trailing slash, requests without it are redirected.
+func (s *FileServer) Serve(w *response.Writer, req *request.Request) {
+	name := strings.TrimPrefix(req.PathValue("*"), "/")
+	info, err := s.root.Stat(nameOrDot(name))
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+
+	if !info.IsDir() {
+		s.ServeFile(w, req, name)
+		return
+	}
+
+	if !strings.HasSuffix(req.Target.Path, "/") {
+		redirect(w, req.Target.Path+"/")
+		return
+	}
+
+	if s.Index {
+		index := path.Join(name, "index.html")
+		if info, err := s.root.Stat(index); err == nil && !info.IsDir() {
+			s.ServeFile(w, req, index)
+			return
+		}
+	}
+
+	if !s.Listing {
+		writeStatus(w, response.NotFound)
+		return
+	}
+
+	s.serveListing(w, name)
+}
+
+// ServeFile serves the named file below the root, regardless of the request
+// path. It answers conditional requests with 304 Not Modified and range
+// requests as response.ServeContent does.
+func (s *FileServer) ServeFile(w *response.Writer, req *request.Request, name string) {
+	f, err := s.root.Open(nameOrDot(name))
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		fmt.Printf("error reading file info: %v\n", err)
+		writeStatus(w, response.InternalError)
+		return
+	}
+
+	if info.IsDir() {
+		writeStatus(w, response.NotFound)
+		return
+	}
+
+	modTime := info.ModTime().UTC().Truncate(time.Second)
+	etag := fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+	h := headers.NewHeaders()
+	h.Set("Content-Type", contentType(name))
+	h.Set("Last-Modified", modTime.Format(response.TimeFormat))
+	h.Set("ETag", etag)
+
+	if notModified(req, etag, modTime) {
+		h.Del("Content-Type")
+		w.WriteStatusLine(response.NotModified)
+		w.WriteHeaders(h)
+		return
+	}
+
+	if err := response.ServeContent(w, req.Headers, f, h); err != nil {
+		fmt.Printf("error sending file: %v\n", err)
+	}
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when there is
+// no If-None-Match, as described in RFC 9110, section 13.2.2.
+func notModified(req *request.Request, etag string, modTime time.Time) bool {
+	if values := req.Headers.Values("if-none-match"); len(values) > 0 {
+		for _, value := range values {
+			for tag := range strings.SplitSeq(value, ",") {
+				tag = strings.TrimSpace(tag)
+				// weak comparison, W/"x" matches "x"
+				if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+
+	value, found := req.Headers.Get("if-modified-since")
+	if !found {
+		return false
+	}
+
+	since, err := response.ParseTime(value)
+
+	return err == nil && !modTime.After(since)
+}
+
+func contentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+
+	if contentType, found := extraTypes[ext]; found {
+		return contentType
+	}
+
+	return "application/octet-stream"
+}
+
+func (s *FileServer) serveListing(w *response.Writer, name string) {
+	dir, err := s.root.Open(nameOrDot(name))
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	defer dir.Close()
+
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		fmt.Printf("error reading directory: %v\n", err)
+		writeStatus(w, response.InternalError)
+		return
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		names = append(names, entryName)
+	}
+	slices.Sort(names)
+
+	var body strings.Builder
+	title := html.EscapeString("/" + name)
+	fmt.Fprintf(&body, "<html>\n  <head>\n    <title>%s</title>\n  </head>\n", title)
+	fmt.Fprintf(&body, "  <body>\n    <h1>%s</h1>\n    <ul>\n", title)
+	for _, entryName := range names {
+		// escaped, and prefixed with "./" if a colon would make it
+		// look like a scheme
+		link := (&url.URL{Path: entryName}).String()
+		fmt.Fprintf(
+			&body,
+			"      <li><a href=\"%s\">%s</a></li>\n",
+			html.EscapeString(link),
+			html.EscapeString(entryName),
+		)
+	}
+	body.WriteString("    </ul>\n  </body>\n</html>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteStatusLine(response.Ok)
+	w.Write([]byte(body.String()))
+}
+
+// nameOrDot turns the empty name of the root itself into ".".
+func nameOrDot(name string) string {
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
+
+// writeOpenError answers a request for a file that could not be opened.
+// Paths escaping the root end up here as well and are not found either.
+func writeOpenError(w *response.Writer, err error) {
+	if errors.Is(err, fs.ErrPermission) {
+		writeStatus(w, response.Forbidden)
+		return
+	}
+
+	writeStatus(w, response.NotFound)
+}
+
+func redirect(w *response.Writer, location string) {
+	w.WriteStatusLine(response.MovedPermanently)
+	body := []byte("301 Moved Permanently")
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Location", (&url.URL{Path: location}).EscapedPath())
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+func writeStatus(w *response.Writer, statusCode response.StatusCode) {
+	w.WriteStatusLine(statusCode)
+	body := fmt.Appendf(nil, "%d %s", statusCode, response.StatusText(statusCode))
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
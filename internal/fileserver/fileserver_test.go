@@ -0,0 +1,238 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nordluma/httpfromtcp/internal/request"
+	"github.com/nordluma/httpfromtcp/internal/response"
+	"github.com/nordluma/httpfromtcp/internal/router"
+)
+
+const content = "0123456789abcdefghij"
+
+func newTestServer(t *testing.T) (*FileServer, string) {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"file.txt":        content,
+		"docs/index.html": "<h1>docs</h1>",
+		"pub/a&b.txt":     "a",
+		"pub/sub/x.json":  "{}",
+	}
+	for name, data := range files {
+		full := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+		require.NoError(t, os.WriteFile(full, []byte(data), 0o644))
+	}
+
+	s, err := New(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	return s, dir
+}
+
+func get(t *testing.T, s *FileServer, target string, extraHeaders ...string) *http.Response {
+	t.Helper()
+	rt := router.New()
+	rt.Get("/static/*", s.Serve)
+
+	raw := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: localhost\r\n", target)
+	for _, h := range extraHeaders {
+		raw += h + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	rt.Serve(w, req)
+	require.NoError(t, w.Finish())
+
+	res, err := http.ReadResponse(bufio.NewReader(&buf), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { res.Body.Close() })
+
+	return res
+}
+
+func readBody(t *testing.T, res *http.Response) string {
+	t.Helper()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestServeFile(t *testing.T) {
+	s, _ := newTestServer(t)
+	res := get(t, s, "/static/file.txt")
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/plain; charset=utf-8", res.Header.Get("Content-Type"))
+	assert.Equal(t, "bytes", res.Header.Get("Accept-Ranges"))
+	assert.NotEmpty(t, res.Header.Get("ETag"))
+	assert.NotEmpty(t, res.Header.Get("Last-Modified"))
+	assert.Equal(t, int64(len(content)), res.ContentLength)
+	assert.Equal(t, content, readBody(t, res))
+}
+
+func TestServeFileForHead(t *testing.T) {
+	s, _ := newTestServer(t)
+	rt := router.New()
+	rt.Get("/static/*", s.Serve)
+	req, err := request.RequestFromReader(
+		strings.NewReader("HEAD /static/file.txt HTTP/1.1\r\nHost: localhost\r\n\r\n"),
+	)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	w.SetMethod("HEAD")
+	rt.Serve(w, req)
+	require.NoError(t, w.Finish())
+
+	// the headers of a GET, without the body
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, fmt.Sprintf("Content-Length: %d\r\n", len(content)))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"), out)
+	assert.NotContains(t, out, content)
+	assert.True(t, w.KeepAlive())
+}
+
+func TestServeMissingFiles(t *testing.T) {
+	s, _ := newTestServer(t)
+	assert.Equal(t, http.StatusNotFound, get(t, s, "/static/missing.txt").StatusCode)
+	assert.Equal(t, http.StatusNotFound, get(t, s, "/static/file.txt/x").StatusCode)
+}
+
+func TestServeBlocksPathTraversal(t *testing.T) {
+	s, dir := newTestServer(t)
+	outside := filepath.Join(filepath.Dir(dir), "outside.txt")
+	require.NoError(t, os.WriteFile(outside, []byte("secret"), 0o644))
+	t.Cleanup(func() { os.Remove(outside) })
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "link.txt")))
+
+	// dot segments are removed from the target before routing
+	res := get(t, s, "/static/../../outside.txt")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res = get(t, s, "/static/%2e%2e/outside.txt")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res = get(t, s, "/static/link.txt")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// and os.Root catches the ones that get past the router
+	req, err := request.RequestFromReader(
+		strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"),
+	)
+	require.NoError(t, err)
+	req.SetPathValue("*", "../outside.txt")
+	var buf bytes.Buffer
+	s.Serve(response.NewWriter(&buf), req)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 404 Not Found"))
+	assert.NotContains(t, buf.String(), "secret")
+}
+
+func TestServeDirectories(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	res := get(t, s, "/static/docs")
+	assert.Equal(t, http.StatusMovedPermanently, res.StatusCode)
+	assert.Equal(t, "/static/docs/", res.Header.Get("Location"))
+
+	res = get(t, s, "/static/docs/")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "<h1>docs</h1>", readBody(t, res))
+
+	// no listings unless enabled
+	assert.Equal(t, http.StatusNotFound, get(t, s, "/static/pub/").StatusCode)
+
+	s.Listing = true
+	res = get(t, s, "/static/pub/")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+	body := readBody(t, res)
+	assert.Contains(t, body, `<a href="a&amp;b.txt">a&amp;b.txt</a>`)
+	assert.Contains(t, body, `<a href="sub/">sub/</a>`)
+
+	s.Index = false
+	res = get(t, s, "/static/docs/")
+	assert.Contains(t, readBody(t, res), `<a href="index.html">`)
+}
+
+func TestConditionalRequests(t *testing.T) {
+	s, _ := newTestServer(t)
+	res := get(t, s, "/static/file.txt")
+	etag := res.Header.Get("ETag")
+	lastModified := res.Header.Get("Last-Modified")
+
+	cases := []struct {
+		header string
+		status int
+	}{
+		{header: "If-None-Match: " + etag, status: http.StatusNotModified},
+		{header: "If-None-Match: \"other\", W/" + etag, status: http.StatusNotModified},
+		{header: "If-None-Match: *", status: http.StatusNotModified},
+		{header: "If-None-Match: \"other\"", status: http.StatusOK},
+		{header: "If-Modified-Since: " + lastModified, status: http.StatusNotModified},
+		{
+			header: "If-Modified-Since: " +
+				time.Now().Add(-time.Hour).UTC().Format(response.TimeFormat),
+			status: http.StatusOK,
+		},
+		{header: "If-Modified-Since: yesterday", status: http.StatusOK},
+	}
+
+	for _, c := range cases {
+		res := get(t, s, "/static/file.txt", c.header)
+		assert.Equal(t, c.status, res.StatusCode, c.header)
+		if c.status == http.StatusNotModified {
+			assert.Equal(t, etag, res.Header.Get("ETag"), c.header)
+			assert.Empty(t, readBody(t, res), c.header)
+		}
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since
+	res = get(t, s, "/static/file.txt",
+		"If-None-Match: \"other\"",
+		"If-Modified-Since: "+lastModified,
+	)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestSingleRange(t *testing.T) {
+	s, _ := newTestServer(t)
+	cases := []struct {
+		rangeHeader  string
+		contentRange string
+		body         string
+	}{
+		{rangeHeader: "bytes=0-4", contentRange: "bytes 0-4/20", body: "01234"},
+		{rangeHeader: "bytes=15-", contentRange: "bytes 15-19/20", body: "fghij"},
+		{rangeHeader: "bytes=-3", contentRange: "bytes 17-19/20", body: "hij"},
+		{rangeHeader: "bytes=18-100", contentRange: "bytes 18-19/20", body: "ij"},
+		{rangeHeader: "bytes=-100", contentRange: "bytes 0-19/20", body: content},
+		{rangeHeader: "bytes=50-60, 2-3", contentRange: "bytes 2-3/20", body: "23"},
+	}
+
+	for _, c := range cases {
+		res := get(t, s, "/static/file.txt", "Range: "+c.rangeHeader)
+		assert.Equal(t, http.StatusPartialContent, res.StatusCode, c.rangeHeader)
+		assert.Equal(t, c.contentRange, res.Header.Get("Content-Range"), c.rangeHeader)
+		assert.Equal(t, "text/plain; charset=utf-8", res.Header.Get("Content-Type"))
+		assert.Equal(t, c.body, readBody(t, res), c.rangeHeader)
+	}
+}
@@ -2,18 +2,77 @@ package headers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"iter"
 	"slices"
 	"strings"
 )
 
-type Headers map[string]string
+type field struct {
+	// name keeps the casing the field was first given with
+	name   string
+	values []string
+}
+
+// Headers is an ordered collection of header fields. Field names are matched
+// case-insensitively but written out with their original casing, in the
+// order they were first added, and every value of a repeated field is kept
+// separately.
+type Headers struct {
+	// ObsFold controls how Parse treats obsolete line folding.
+	ObsFold ObsFoldPolicy
+
+	fields []*field
+	// index maps lowercased field names to their position in fields
+	index map[string]int
+	// last is the field Parse added a value to most recently, which a
+	// folded line continues
+	last *field
+}
+
+// ObsFoldPolicy decides what happens to a field line starting with
+// whitespace, which continues the previous field value in the obsolete line
+// folding syntax (RFC 9112, section 5.2).
+type ObsFoldPolicy int
+
+const (
+	// ObsFoldReject fails parsing with ErrObsFold.
+	ObsFoldReject ObsFoldPolicy = iota
+	// ObsFoldUnfold replaces the fold with a single space.
+	ObsFoldUnfold
+)
+
+var (
+	ErrInvalidFieldName  = errors.New("invalid field name")
+	ErrInvalidFieldValue = errors.New("invalid field value")
+	ErrObsFold           = errors.New("obsolete line folding")
+	// ErrMalformedFieldLine is returned for a field line without a colon.
+	ErrMalformedFieldLine = errors.New("malformed field line")
+)
 
-func NewHeaders() Headers {
-	return Headers{}
+// FieldError describes a field line that could not be parsed. Err is one of
+// the sentinel errors of this package.
+type FieldError struct {
+	Line string
+	Err  error
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%v: %q", e.Err, e.Line)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+func NewHeaders() *Headers {
+	return &Headers{
+		index: make(map[string]int),
+	}
+}
+
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
 	idx := bytes.Index(data, []byte("\r\n"))
 	if idx == -1 {
 		// need more data
@@ -26,46 +85,171 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	}
 
 	headerStr := string(data[:idx])
-	pair := strings.SplitN(headerStr, ":", 2)
+	if headerStr[0] == ' ' || headerStr[0] == '\t' {
+		if err := h.unfold(headerStr); err != nil {
+			return 0, false, err
+		}
+
+		return idx + 2, false, nil
+	}
+
+	key, value, found := strings.Cut(headerStr, ":")
+	if !found {
+		return 0, false, &FieldError{Line: headerStr, Err: ErrMalformedFieldLine}
+	}
 
-	key, value := pair[0], pair[1]
 	key, err = parseHeaderKey(key)
 	if err != nil {
-		return 0, false, err
+		return 0, false, &FieldError{Line: headerStr, Err: ErrInvalidFieldName}
 	}
 
-	h.Set(key, value)
+	if !isValidFieldValue(value) {
+		return 0, false, &FieldError{Line: headerStr, Err: ErrInvalidFieldValue}
+	}
+
+	h.Add(key, value)
+	h.last = h.field(key)
 
 	// amount of bytes read is index + CRLF (2)
 	return idx + 2, false, err
 }
 
-func (h Headers) Set(key, value string) {
-	key = strings.ToLower(key)
+// unfold appends a folded line to the value parsed last.
+func (h *Headers) unfold(line string) error {
+	if h.ObsFold != ObsFoldUnfold || h.last == nil {
+		return &FieldError{Line: line, Err: ErrObsFold}
+	}
+
+	if !isValidFieldValue(line) {
+		return &FieldError{Line: line, Err: ErrInvalidFieldValue}
+	}
+
+	i := len(h.last.values) - 1
+	h.last.values[i] = strings.TrimSpace(h.last.values[i] + " " + strings.TrimSpace(line))
+
+	return nil
+}
+
+// isValidFieldValue reports whether value only holds visible characters,
+// spaces, tabs and obs-text as allowed by RFC 9110, section 5.5.
+func isValidFieldValue(value string) bool {
+	for _, char := range []byte(value) {
+		isVisible := char > 0x20 && char != 0x7f
+		if char == ' ' || char == '\t' || isVisible {
+			continue
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// Get returns the first value of the field key.
+func (h *Headers) Get(key string) (string, bool) {
+	f := h.field(key)
+	if f == nil || len(f.values) == 0 {
+		return "", false
+	}
+
+	return f.values[0], true
+}
+
+// Values returns all values of the field key in the order they were added.
+func (h *Headers) Values(key string) []string {
+	f := h.field(key)
+	if f == nil {
+		return nil
+	}
+
+	return slices.Clone(f.values)
+}
+
+// Add appends value to the field key, creating the field if needed.
+func (h *Headers) Add(key, value string) {
 	value = strings.TrimSpace(value)
+	if f := h.field(key); f != nil {
+		f.values = append(f.values, value)
+		return
+	}
 
-	existingValue, found := h[key]
-	if found {
-		h[key] = existingValue + ", " + value
-	} else {
-		h[key] = value
+	h.index[strings.ToLower(key)] = len(h.fields)
+	h.fields = append(h.fields, &field{name: key, values: []string{value}})
+}
+
+// Set replaces all values of the field key with value. An existing field
+// keeps its position but takes on the casing of key.
+func (h *Headers) Set(key, value string) {
+	value = strings.TrimSpace(value)
+	if f := h.field(key); f != nil {
+		f.name = key
+		f.values = []string{value}
+		return
 	}
+
+	h.Add(key, value)
 }
 
-func (h Headers) Get(key string) (string, bool) {
+// Del removes the field key and all of its values.
+func (h *Headers) Del(key string) {
 	key = strings.ToLower(key)
-	value, found := h[key]
+	i, found := h.index[key]
+	if !found {
+		return
+	}
+
+	if h.last == h.fields[i] {
+		h.last = nil
+	}
 
-	return value, found
+	h.fields = slices.Delete(h.fields, i, i+1)
+	delete(h.index, key)
+	for j := i; j < len(h.fields); j++ {
+		h.index[strings.ToLower(h.fields[j].name)] = j
+	}
 }
 
-func (h Headers) Override(key, value string) {
-	h[key] = value
+// Len returns the number of distinct fields.
+func (h *Headers) Len() int {
+	return len(h.fields)
 }
 
-func (h Headers) Delete(key string) {
-	key = strings.ToLower(key)
-	delete(h, key)
+// All yields every field name and value pair in order. Fields with several
+// values are yielded once per value.
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for _, f := range h.fields {
+			for _, value := range f.values {
+				if !yield(f.name, value) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// HasToken reports whether any value of the comma-separated list field key
+// contains token. Tokens are compared case-insensitively, as required for
+// fields such as Connection and Transfer-Encoding.
+func (h *Headers) HasToken(key, token string) bool {
+	for _, value := range h.Values(key) {
+		for part := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (h *Headers) field(key string) *field {
+	i, found := h.index[strings.ToLower(key)]
+	if !found {
+		return nil
+	}
+
+	return h.fields[i]
 }
 
 var allowedSpecialChars = []rune{
@@ -86,12 +270,13 @@ var allowedSpecialChars = []rune{
 	'~',
 }
 
+// parseHeaderKey checks that key is a non-empty token. Whitespace is not a
+// token character, so a name followed by spaces before the colon is rejected.
 func parseHeaderKey(key string) (string, error) {
-	if key[len(key)-1] == ' ' {
-		return "", fmt.Errorf("Invalid header name: %s", key)
+	if key == "" {
+		return "", fmt.Errorf("Empty header name")
 	}
 
-	key = strings.TrimSpace(key)
 	for _, char := range key {
 		isUpperChar := char >= 'A' && char <= 'Z'
 		isLowerChar := char >= 'a' && char <= 'z'
@@ -44,8 +44,8 @@ func TestParseSingleHeaderWithExtraSpace(t *testing.T) {
 
 func TestParseTwoHeadersWithExistingHeaders(t *testing.T) {
 	headers := NewHeaders()
-	headers.Set("Content-Type", "application/json")
-	headers.Set("Accept-Encoding", "gzip")
+	headers.Add("Content-Type", "application/json")
+	headers.Add("Accept-Encoding", "gzip")
 	data := []byte("Host: localhost:42069\r\nContent-Length: 55\r\n\r\n")
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
@@ -119,7 +119,7 @@ func TestCaseInsensitiveHeaders(t *testing.T) {
 
 	headers := NewHeaders()
 	for _, c := range cases {
-		headers.Set(c.setter, c.value)
+		headers.Add(c.setter, c.value)
 		value, found := headers.Get(c.getter)
 		assert.True(t, found)
 		assert.Equal(t, value, c.value)
@@ -129,10 +129,170 @@ func TestCaseInsensitiveHeaders(t *testing.T) {
 func TestAddMultipleValuesToSingleHeader(t *testing.T) {
 	headers := NewHeaders()
 	for _, value := range []string{"one", "two", "three"} {
-		headers.Set("custom", value)
+		headers.Add("custom", value)
 	}
 
 	value, found := headers.Get("custom")
 	assert.True(t, found)
-	assert.Equal(t, "one, two, three", value)
+	assert.Equal(t, "one", value)
+	assert.Equal(t, []string{"one", "two", "three"}, headers.Values("CUSTOM"))
+}
+
+func TestSetReplacesAllValues(t *testing.T) {
+	headers := NewHeaders()
+	headers.Add("Accept", "text/html")
+	headers.Add("Accept", "text/plain")
+	headers.Set("ACCEPT", "*/*")
+
+	assert.Equal(t, []string{"*/*"}, headers.Values("accept"))
+	assert.Equal(t, 1, headers.Len())
+}
+
+func TestDelRemovesField(t *testing.T) {
+	headers := NewHeaders()
+	headers.Set("Host", "localhost")
+	headers.Set("Accept", "*/*")
+	headers.Set("User-Agent", "curl/7.81")
+	headers.Del("accept")
+
+	_, found := headers.Get("Accept")
+	assert.False(t, found)
+	value, found := headers.Get("User-Agent")
+	assert.True(t, found)
+	assert.Equal(t, "curl/7.81", value)
+	assert.Equal(t, 2, headers.Len())
+}
+
+func TestAllKeepsOrderCasingAndValues(t *testing.T) {
+	headers := NewHeaders()
+	headers.Set("Content-Type", "text/html")
+	headers.Add("Set-Cookie", "a=1; Path=/")
+	headers.Add("X-Custom", "yes")
+	headers.Add("set-cookie", "b=2, c=3")
+
+	type pair struct{ key, value string }
+	var pairs []pair
+	for key, value := range headers.All() {
+		pairs = append(pairs, pair{key, value})
+	}
+
+	assert.Equal(t, []pair{
+		{"Content-Type", "text/html"},
+		{"Set-Cookie", "a=1; Path=/"},
+		{"Set-Cookie", "b=2, c=3"},
+		{"X-Custom", "yes"},
+	}, pairs)
+}
+
+func TestInvalidCharacterInHeaderValue(t *testing.T) {
+	for _, value := range []string{"a\x00b", "a\rb", "a\x1bb", "a\x7fb"} {
+		headers := NewHeaders()
+		n, done, err := headers.Parse([]byte("X-Test: " + value + "\r\n\r\n"))
+		require.ErrorIs(t, err, ErrInvalidFieldValue, "value %q", value)
+		assert.Equal(t, 0, n)
+		assert.False(t, done)
+	}
+}
+
+func TestHeaderValueAllowsTabsAndObsText(t *testing.T) {
+	headers := NewHeaders()
+	_, _, err := headers.Parse([]byte("X-Test: caf\xe9\tau lait\r\n\r\n"))
+	require.NoError(t, err)
+	value, _ := headers.Get("X-Test")
+	assert.Equal(t, "caf\xe9\tau lait", value)
+}
+
+func TestObsFoldRejectedByDefault(t *testing.T) {
+	headers := NewHeaders()
+	data := []byte("X-Test: first\r\n  second\r\n\r\n")
+	n, _, err := headers.Parse(data)
+	require.NoError(t, err)
+
+	_, _, err = headers.Parse(data[n:])
+	require.ErrorIs(t, err, ErrObsFold)
+	var fieldErr *FieldError
+	require.ErrorAs(t, err, &fieldErr)
+	assert.Equal(t, "  second", fieldErr.Line)
+}
+
+func TestObsFoldUnfolded(t *testing.T) {
+	headers := NewHeaders()
+	headers.ObsFold = ObsFoldUnfold
+	data := []byte("X-Test: first\r\n  second\r\n\tthird\r\nHost: localhost\r\n\r\n")
+	for {
+		n, done, err := headers.Parse(data)
+		require.NoError(t, err)
+		data = data[n:]
+		if done {
+			break
+		}
+	}
+
+	assert.Equal(t, []string{"first second third"}, headers.Values("X-Test"))
+	value, _ := headers.Get("Host")
+	assert.Equal(t, "localhost", value)
+}
+
+func TestObsFoldWithoutPreviousField(t *testing.T) {
+	headers := NewHeaders()
+	headers.ObsFold = ObsFoldUnfold
+	_, _, err := headers.Parse([]byte(" X-Test: value\r\n\r\n"))
+	require.ErrorIs(t, err, ErrObsFold)
+}
+
+func TestMalformedFieldLines(t *testing.T) {
+	cases := []struct {
+		line string
+		err  error
+	}{
+		{line: "no colon here", err: ErrMalformedFieldLine},
+		{line: ": empty name", err: ErrInvalidFieldName},
+		{line: "Host\t: tab before colon", err: ErrInvalidFieldName},
+		{line: ":", err: ErrInvalidFieldName},
+	}
+
+	for _, c := range cases {
+		headers := NewHeaders()
+		n, done, err := headers.Parse([]byte(c.line + "\r\n\r\n"))
+		require.ErrorIs(t, err, c.err, "line %q", c.line)
+		assert.Equal(t, 0, n)
+		assert.False(t, done)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add([]byte("Host: localhost:42069\r\n\r\n"))
+	f.Add([]byte("Host: localhost\r\nAccept: */*\r\nAccept: text/html\r\n\r\n"))
+	f.Add([]byte("no colon\r\n\r\n"))
+	f.Add([]byte(":\r\n\r\n"))
+	f.Add([]byte("X-Test: a\r\n folded\r\n\r\n"))
+	f.Add([]byte("X-Test: \x00\x7f\xff\r\n\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, policy := range []ObsFoldPolicy{ObsFoldReject, ObsFoldUnfold} {
+			headers := NewHeaders()
+			headers.ObsFold = policy
+			rest := data
+			for {
+				n, done, err := headers.Parse(rest)
+				if err != nil {
+					var fieldErr *FieldError
+					require.ErrorAs(t, err, &fieldErr)
+					break
+				}
+
+				require.LessOrEqual(t, n, len(rest))
+				if done || n == 0 {
+					break
+				}
+				rest = rest[n:]
+			}
+
+			for name, value := range headers.All() {
+				_, err := parseHeaderKey(name)
+				require.NoError(t, err)
+				require.True(t, isValidFieldValue(value), "value %q", value)
+			}
+		}
+	})
 }
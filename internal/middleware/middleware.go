@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/nordluma/httpfromtcp/internal/request"
+	"github.com/nordluma/httpfromtcp/internal/response"
+	"github.com/nordluma/httpfromtcp/internal/server"
+)
+
+// Middleware wraps a handler with behaviour that applies to every request.
+type Middleware func(server.Handler) server.Handler
+
+// Chain wraps handler with middlewares. The first middleware is the outermost
+// one, so it sees the request first and the response last.
+func Chain(handler server.Handler, middlewares ...Middleware) server.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
+
+// Recover turns a panicking handler into a 500 Internal Server Error. If the
+// handler already started its response the connection is closed instead, as
+// the client cannot tell the response was cut short otherwise.
+func Recover(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf(
+					"panic serving %s %s: %v\n%s",
+					req.RequestLine.Method,
+					req.RequestLine.RequestTarget,
+					err,
+					debug.Stack(),
+				)
+
+				w.Fail()
+			}
+		}()
+
+		next(w, req)
+	}
+}
+
+// Logger logs the method, target, status, body size and duration of every
+// request to logger.
+func Logger(logger *log.Logger) Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+
+			requestID, _ := req.Headers.Get(RequestIDHeader)
+			logger.Printf(
+				"%s %s %d %dB %s %s",
+				req.RequestLine.Method,
+				req.RequestLine.RequestTarget,
+				w.StatusCode(),
+				w.BytesWritten(),
+				time.Since(start),
+				requestID,
+			)
+		}
+	}
+}
+
+// Compress compresses response bodies with the best content coding the client
+// accepts, as listed in its Accept-Encoding header. Only bodies that compress
+// well are compressed, see response.Writer.SetCompression.
+func Compress(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		w.SetCompression(
+			response.NegotiateEncoding(req.Headers.Values("accept-encoding")),
+		)
+		next(w, req)
+	}
+}
+
+// RequestIDHeader carries the ID that identifies a request across services.
+const RequestIDHeader = "X-Request-Id"
+
+// RequestID makes sure every request carries an ID in the X-Request-Id
+// header, generating one unless the client sent it, and echoes it in the
+// response.
+func RequestID(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		id, found := req.Headers.Get(RequestIDHeader)
+		if !found || id == "" {
+			id = rand.Text()
+			req.Headers.Set(RequestIDHeader, id)
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		next(w, req)
+	}
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nordluma/httpfromtcp/internal/request"
+	"github.com/nordluma/httpfromtcp/internal/response"
+	"github.com/nordluma/httpfromtcp/internal/server"
+)
+
+func serve(t *testing.T, handler server.Handler, rawRequest string) (string, *response.Writer) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(rawRequest))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	handler(w, req)
+
+	return buf.String(), w
+}
+
+func okHandler(w *response.Writer, _ *request.Request) {
+	body := []byte("ok")
+	w.WriteStatusLine(response.Ok)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) {
+				order = append(order, name)
+				next(w, req)
+			}
+		}
+	}
+
+	handler := Chain(okHandler, mark("outer"), mark("inner"))
+	serve(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, []string{"outer", "inner"}, order)
+}
+
+func TestRecoverAnswersWithInternalError(t *testing.T) {
+	handler := Recover(func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	})
+
+	out, w := serve(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error"))
+	assert.Contains(t, out, "Connection: close")
+	assert.False(t, w.KeepAlive())
+}
+
+func TestRecoverAfterResponseStarted(t *testing.T) {
+	handler := Recover(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.Ok)
+		panic("boom")
+	})
+
+	out, w := serve(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", out)
+	assert.False(t, w.KeepAlive())
+}
+
+func TestRequestIDIsGeneratedAndEchoed(t *testing.T) {
+	var seen string
+	handler := RequestID(func(w *response.Writer, req *request.Request) {
+		seen, _ = req.Headers.Get(RequestIDHeader)
+		okHandler(w, req)
+	})
+
+	out, _ := serve(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Len(t, seen, 26)
+	assert.Contains(t, out, "X-Request-Id: "+seen+"\r\n")
+
+	out, _ = serve(t, handler,
+		"GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-Id: abc\r\n\r\n",
+	)
+	assert.Equal(t, "abc", seen)
+	assert.Contains(t, out, "X-Request-Id: abc\r\n")
+}
+
+func TestLoggerWritesRequestSummary(t *testing.T) {
+	var logs bytes.Buffer
+	handler := Logger(log.New(&logs, "", 0))(okHandler)
+
+	serve(t, handler, "GET /logged HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(logs.String(), "GET /logged 200 2B "))
+}
+
+func TestCompressUsesAcceptEncoding(t *testing.T) {
+	body := strings.Repeat("compress me ", 100)
+	handler := Compress(func(w *response.Writer, _ *request.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(body))
+		w.Finish()
+	})
+
+	out, _ := serve(t, handler,
+		"GET / HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: deflate;q=0.5, gzip\r\n\r\n",
+	)
+	assert.Contains(t, out, "Content-Encoding: gzip\r\n")
+	assert.Contains(t, out, "Vary: Accept-Encoding\r\n")
+	assert.NotContains(t, out, body)
+
+	out, _ = serve(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.NotContains(t, out, "Content-Encoding")
+	assert.Contains(t, out, "Vary: Accept-Encoding\r\n")
+	assert.Contains(t, out, body)
+}
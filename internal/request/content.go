@@ -0,0 +1,126 @@
+package request
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrUnsupportedContentCoding is returned when Reader.DecodeContent is set
+// and a request body is encoded with a content coding other than gzip or
+// deflate.
+var ErrUnsupportedContentCoding = errors.New("unsupported content coding")
+
+// ErrCorruptContent is returned when the content codings of a request body
+// cannot be undone, including for bodies that end before their encoded
+// content does or that carry more.
+var ErrCorruptContent = errors.New("corrupt encoded body")
+
+// parseContentCodings returns the content codings applied to the body in the
+// order they were applied, leaving out identity.
+func (r *Request) parseContentCodings() ([]string, error) {
+	var codings []string
+	for _, value := range r.Headers.Values("content-encoding") {
+		for coding := range strings.SplitSeq(value, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			switch coding {
+			case "", "identity":
+			case "gzip", "x-gzip":
+				codings = append(codings, "gzip")
+			case "deflate":
+				codings = append(codings, "deflate")
+			default:
+				return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentCoding, coding)
+			}
+		}
+	}
+
+	return codings, nil
+}
+
+// setupContentDecoding takes note of the content codings of the body so that it is
+// decoded as it is read. The Content-Encoding and Content-Length headers
+// describe the encoded body and are removed.
+func (r *Request) setupContentDecoding() error {
+	codings, err := r.parseContentCodings()
+	if err != nil {
+		return err
+	}
+
+	if len(codings) == 0 {
+		return nil
+	}
+
+	r.contentCodings = codings
+	r.Headers.Del("Content-Encoding")
+	r.Headers.Del("Content-Length")
+
+	return nil
+}
+
+// newContentDecoder undoes the content codings of the request body read from
+// body, last applied first. Every stage is held to maxDecodedBytes, as a few
+// kilobytes of compressed data can expand to gigabytes.
+func newContentDecoder(body io.Reader, codings []string, maxDecodedBytes int) (io.Reader, error) {
+	decoded := body
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		switch codings[i] {
+		case "gzip":
+			decoded, err = gzip.NewReader(decoded)
+		case "deflate":
+			decoded, err = zlib.NewReader(decoded)
+		}
+		if err != nil {
+			// not wrapped, io.EOF from an empty body does not mean
+			// that the client went away
+			return nil, fmt.Errorf(
+				"%w: decoding %s body: %v",
+				ErrCorruptContent,
+				codings[i],
+				err,
+			)
+		}
+
+		if maxDecodedBytes > 0 {
+			decoded = &decodedLimit{r: decoded, limit: maxDecodedBytes}
+		}
+	}
+
+	return decoded, nil
+}
+
+// decodedLimit fails with ErrBodyTooLarge once more than limit bytes have
+// been decoded.
+type decodedLimit struct {
+	r     io.Reader
+	limit int
+	read  int
+}
+
+func (l *decodedLimit) Read(p []byte) (int, error) {
+	if l.read > l.limit {
+		return 0, l.err()
+	}
+
+	// read one byte past the limit to tell a body of exactly the limit
+	// from a larger one
+	if remaining := l.limit - l.read + 1; len(p) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := l.r.Read(p)
+	l.read += n
+	if l.read > l.limit {
+		return n - 1, l.err()
+	}
+
+	return n, err
+}
+
+func (l *decodedLimit) err() error {
+	return fmt.Errorf("%w: decoded body exceeds %d bytes", ErrBodyTooLarge, l.limit)
+}
@@ -2,6 +2,7 @@ package request
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -18,14 +19,307 @@ const (
 	reqStateInitialized requestState = iota
 	reqStateParsingHeaders
 	reqStateParsingBody
+	reqStateParsingChunkSize
+	reqStateParsingChunkData
+	reqStateParsingChunkDataEnd
+	reqStateParsingTrailers
 	reqStateDone
 )
 
 type Request struct {
 	RequestLine RequestLine
-	Headers     headers.Headers
+	Headers     *headers.Headers
 	Body        []byte
-	state       requestState
+	// Target is the parsed RequestLine.RequestTarget.
+	Target Target
+	// Trailers holds the trailer fields sent after a chunked body.
+	Trailers *headers.Headers
+
+	pathValues map[string]string
+
+	state          requestState
+	chunkRemaining int
+	headerBytes    int
+	maxHeaderBytes int
+	maxBodyBytes   int
+	strict         bool
+	contentLength  int
+	// closeAfter is set for requests whose framing was accepted but makes
+	// the connection unfit for reuse.
+	closeAfter bool
+	// expectContinue is set when the client asked for 100 Continue,
+	// sendContinue sends it and is cleared once it has been called.
+	expectContinue bool
+	sendContinue   func() error
+	// contentCodings are the content codings to undo while reading the
+	// body, set only when decodeContent is.
+	decodeContent   bool
+	contentCodings  []string
+	maxDecodedBytes int
+
+	// decoded holds body bytes decoded from the wire that have not been
+	// handed out yet, bodyLen counts all decoded body bytes.
+	decoded []byte
+	bodyLen int
+	stream  *bodyReader
+	// buffered is set once ReadBody has read the body into Body.
+	buffered bool
+}
+
+func (r *Request) appendBody(data []byte) {
+	r.decoded = append(r.decoded, data...)
+	r.bodyLen += len(data)
+}
+
+// PathValue returns the value of the named path parameter captured by the
+// router, or an empty string if there is none.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+// SetPathValue stores a path parameter so that handlers can read it with
+// PathValue.
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = make(map[string]string)
+	}
+
+	r.pathValues[name] = value
+}
+
+// OnContinue registers send to be called when the body of a request carrying
+// "Expect: 100-continue" is read for the first time, so that the client is
+// told to go ahead with the upload only once the body is wanted. A handler
+// that answers without reading the body spares the client from sending it.
+// Nothing is registered for requests that do not wait for 100 Continue or
+// whose body has arrived in full already.
+func (r *Request) OnContinue(send func() error) {
+	if r.expectContinue && r.state != reqStateDone {
+		r.sendContinue = send
+	}
+}
+
+// ContinuePending reports whether the client is still waiting for 100
+// Continue before sending the body, which it may never send if the request
+// is answered without reading it.
+func (r *Request) ContinuePending() bool {
+	return r.sendContinue != nil
+}
+
+// BodyReader returns the request body as a stream. For requests read with
+// ReadStreamingRequest the body is read from the connection on demand,
+// otherwise it reads the already buffered Body.
+func (r *Request) BodyReader() io.ReadCloser {
+	if r.stream != nil && !r.buffered {
+		return r.stream
+	}
+
+	return io.NopCloser(bytes.NewReader(r.Body))
+}
+
+// DiscardBody reads and throws away up to limit bytes of a streamed body
+// that the handler left unread, so that the connection is positioned at the
+// start of the next request. It returns an error when the body could not be
+// consumed completely.
+func (r *Request) DiscardBody(limit int64) error {
+	if r.stream == nil || r.state == reqStateDone {
+		return nil
+	}
+
+	if r.sendContinue != nil {
+		// 100 Continue can no longer precede the final response, so
+		// the client may never send the body
+		return ErrBodyNotSent
+	}
+
+	// the body is skipped as sent, there is no need to decode it
+	_, err := io.CopyN(io.Discard, readerFunc(r.stream.read), limit)
+	if err == io.EOF {
+		return nil
+	}
+
+	if err == nil {
+		return fmt.Errorf("unread body exceeds %d bytes", limit)
+	}
+
+	return err
+}
+
+// ReadBody reads the rest of a streamed body into Body, turning the request
+// into a buffered one.
+func (r *Request) ReadBody() error {
+	if r.stream == nil || r.buffered {
+		return nil
+	}
+
+	body, err := io.ReadAll(readerFunc(r.stream.readContent))
+	if err != nil {
+		return err
+	}
+
+	r.Body = body
+	r.buffered = true
+
+	return nil
+}
+
+var (
+	// ErrHeaderTooLarge is returned when the request line and headers, or
+	// the trailers added to them, exceed Reader.MaxHeaderBytes.
+	ErrHeaderTooLarge = errors.New("request header too large")
+	// ErrBodyTooLarge is returned when the request body exceeds
+	// Reader.MaxBodyBytes.
+	ErrBodyTooLarge = errors.New("request body too large")
+	// ErrChunkLineTooLong is returned when the line announcing a chunk is
+	// longer than any sensible chunk size and extensions would make it.
+	ErrChunkLineTooLong = errors.New("chunk size line too long")
+	// ErrAmbiguousFraming is returned when the length of the body cannot be
+	// determined unambiguously from Transfer-Encoding and Content-Length.
+	ErrAmbiguousFraming = errors.New("ambiguous request framing")
+	// ErrUnsupportedTransferCoding is returned for transfer codings other
+	// than chunked.
+	ErrUnsupportedTransferCoding = errors.New("unsupported transfer coding")
+	// ErrInvalidHost is returned when an HTTP/1.1 request has no Host
+	// header, or any request has more than one or a malformed one.
+	ErrInvalidHost = errors.New("invalid Host header")
+	// ErrExpectationFailed is returned for an Expect header other than
+	// 100-continue.
+	ErrExpectationFailed = errors.New("unsupported expectation")
+	// ErrBodyNotSent is returned by DiscardBody when the client still waits
+	// for 100 Continue before sending the body.
+	ErrBodyNotSent = errors.New("client waits for 100 Continue")
+	// ErrUnsupportedVersion is returned for well-formed HTTP versions other
+	// than 1.0 and 1.1.
+	ErrUnsupportedVersion = errors.New("unsupported HTTP version")
+)
+
+// ErrBodyClosed is returned when reading a streamed body after it has been
+// closed.
+var ErrBodyClosed = errors.New("read on closed body")
+
+// bodyReader streams a request body by decoding just as much of the
+// connection as is needed to satisfy each read.
+type bodyReader struct {
+	rdr    *Reader
+	req    *Request
+	closed bool
+	// content decodes the content codings of the body, it is set up on
+	// the first read so that 100 Continue is not sent early
+	content io.Reader
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+
+	return b.readContent(p)
+}
+
+// readContent reads the body with its content codings undone.
+func (b *bodyReader) readContent(p []byte) (int, error) {
+	if len(b.req.contentCodings) == 0 {
+		return b.read(p)
+	}
+
+	if b.content == nil {
+		content, err := newContentDecoder(
+			rawBody{b},
+			b.req.contentCodings,
+			b.req.maxDecodedBytes,
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		b.content = content
+	}
+
+	n, err := b.content.Read(p)
+	if err == io.EOF {
+		// whatever follows the encoded content would otherwise be
+		// read as the next request
+		if err := b.checkContentEnd(); err != nil {
+			return n, err
+		}
+	}
+
+	return n, err
+}
+
+// checkContentEnd makes sure the body as sent ends where its encoded
+// content does.
+func (b *bodyReader) checkContentEnd() error {
+	var buf [1]byte
+	n, err := b.read(buf[:])
+	if n > 0 {
+		return fmt.Errorf("%w: data after the end of the content", ErrCorruptContent)
+	}
+
+	if err != io.EOF {
+		return err
+	}
+
+	return nil
+}
+
+func (b *bodyReader) Close() error {
+	b.closed = true
+	return nil
+}
+
+// read reads the body as sent, regardless of whether the handler closed it.
+func (b *bodyReader) read(p []byte) (int, error) {
+	req := b.req
+	if send := req.sendContinue; send != nil {
+		req.sendContinue = nil
+		if err := send(); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(req.decoded) == 0 && req.state != reqStateDone {
+		err := b.rdr.parseUntil(req, func() bool {
+			return len(req.decoded) > 0 || req.state == reqStateDone
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if len(req.decoded) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, req.decoded)
+	req.decoded = req.decoded[:copy(req.decoded, req.decoded[n:])]
+
+	return n, nil
+}
+
+// rawBody reads the body as sent. It is an io.ByteReader so that the
+// decompressors do not buffer past the end of their stream.
+type rawBody struct {
+	b *bodyReader
+}
+
+func (r rawBody) Read(p []byte) (int, error) {
+	return r.b.read(p)
+}
+
+func (r rawBody) ReadByte() (byte, error) {
+	var buf [1]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+
+	return buf[0], nil
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -59,8 +353,18 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			return 0, nil
 		}
 
+		target, err := parseTarget(reqLine.Method, reqLine.RequestTarget)
+		if err != nil {
+			return 0, err
+		}
+
 		r.RequestLine = *reqLine
+		r.Target = target
 		r.state = reqStateParsingHeaders
+		if reqLine.HttpVersion == Version09 {
+			r.state = reqStateDone
+		}
+		r.headerBytes += n
 
 		return n, nil
 	case reqStateParsingHeaders:
@@ -69,39 +373,110 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			return 0, err
 		}
 
+		r.headerBytes += n
+		if r.maxHeaderBytes > 0 && r.headerBytes > r.maxHeaderBytes {
+			return 0, ErrHeaderTooLarge
+		}
+
 		if done {
+			if err := r.checkHost(); err != nil {
+				return 0, err
+			}
+
+			if err := r.checkExpect(); err != nil {
+				return 0, err
+			}
+
 			// headers have been parsed -> state transition
-			r.state = reqStateParsingBody
+			state, err := r.bodyState()
+			if err != nil {
+				return 0, err
+			}
+
+			if r.decodeContent {
+				if err := r.setupContentDecoding(); err != nil {
+					return 0, err
+				}
+			}
+
+			r.state = state
 		}
 
 		return n, nil
 	case reqStateParsingBody:
-		value, found := r.Headers.Get("content-length")
-
-		// no content-length, we assume that there is no body
-		if !found {
+		// anything past the declared length belongs to the next request
+		n := min(r.contentLength-r.bodyLen, len(data))
+		r.appendBody(data[:n])
+		if r.bodyLen == r.contentLength {
 			r.state = reqStateDone
-			return len(data), nil
 		}
 
-		r.Body = append(r.Body, data...)
-		contentLen := len(r.Body)
-		hContentLen, err := strconv.Atoi(value)
+		return n, nil
+	case reqStateParsingChunkSize:
+		idx := bytes.Index(data, []byte("\r\n"))
+		if idx > maxChunkLineBytes || (idx == -1 && len(data) > maxChunkLineBytes) {
+			return 0, ErrChunkLineTooLong
+		}
+
+		if idx == -1 {
+			return 0, nil
+		}
+
+		size, err := parseChunkSize(string(data[:idx]))
 		if err != nil {
-			return 0, fmt.Errorf("malformed Content-Length: %s", err)
+			return 0, err
 		}
 
-		if contentLen > hContentLen {
-			return 0, fmt.Errorf(
-				"error: payload size larger than defined in content-length",
-			)
+		if r.maxBodyBytes > 0 && r.bodyLen+size > r.maxBodyBytes {
+			return 0, ErrBodyTooLarge
+		}
+
+		r.chunkRemaining = size
+		r.state = reqStateParsingChunkData
+		if size == 0 {
+			// the last chunk is followed by optional trailers
+			r.state = reqStateParsingTrailers
+		}
+
+		return idx + 2, nil
+	case reqStateParsingChunkData:
+		n := min(r.chunkRemaining, len(data))
+		r.appendBody(data[:n])
+		r.chunkRemaining -= n
+		if r.chunkRemaining == 0 {
+			r.state = reqStateParsingChunkDataEnd
+		}
+
+		return n, nil
+	case reqStateParsingChunkDataEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+
+		if !bytes.HasPrefix(data, []byte("\r\n")) {
+			return 0, fmt.Errorf("error: chunk data not terminated by CRLF")
 		}
 
-		if contentLen == hContentLen {
+		r.state = reqStateParsingChunkSize
+
+		return 2, nil
+	case reqStateParsingTrailers:
+		n, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+
+		// trailers share the limit with the headers
+		r.headerBytes += n
+		if r.maxHeaderBytes > 0 && r.headerBytes > r.maxHeaderBytes {
+			return 0, ErrHeaderTooLarge
+		}
+
+		if done {
 			r.state = reqStateDone
 		}
 
-		return len(data), nil
+		return n, nil
 	case reqStateDone:
 		return 0, fmt.Errorf("error: trying to read data in done state")
 	default:
@@ -109,55 +484,372 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	}
 }
 
+// KeepAlive reports whether the connection may be reused for another request
+// once the response has been sent. It is false when the client asked to close
+// the connection, for HTTP/1.0 unless it asked to keep it alive, and when the
+// request was framed in a way that leaves the start of the next request in
+// doubt.
+func (r *Request) KeepAlive() bool {
+	if r.closeAfter || r.Headers.HasToken("connection", "close") {
+		return false
+	}
+
+	switch r.RequestLine.HttpVersion {
+	case Version11:
+		return true
+	case Version10:
+		// persistent connections are opt-in for HTTP/1.0
+		return r.Headers.HasToken("connection", "keep-alive")
+	default:
+		return false
+	}
+}
+
+// Host returns the host the request was sent to, taken from an absolute-form
+// target or else the Host header, as RFC 9112, section 3.2.2 requires. The
+// port is included when the client sent one.
+func (r *Request) Host() string {
+	if r.Target.Form == AbsoluteForm {
+		return r.Target.Host
+	}
+
+	host, _ := r.Headers.Get("host")
+
+	return host
+}
+
+// checkHost enforces the single Host header HTTP/1.1 requires, HTTP/1.0
+// clients may leave it out.
+func (r *Request) checkHost() error {
+	hosts := r.Headers.Values("host")
+	switch {
+	case len(hosts) == 0 && r.RequestLine.HttpVersion == Version11:
+		return fmt.Errorf("%w: missing", ErrInvalidHost)
+	case len(hosts) > 1:
+		return fmt.Errorf("%w: sent %d times", ErrInvalidHost, len(hosts))
+	case len(hosts) == 1 && strings.ContainsAny(hosts[0], " \t,/?#@"):
+		return fmt.Errorf("%w: %q", ErrInvalidHost, hosts[0])
+	}
+
+	return nil
+}
+
+// checkExpect accepts 100-continue as the only expectation. HTTP/1.0 clients
+// predate Expect, so it is ignored for them.
+func (r *Request) checkExpect() error {
+	if r.RequestLine.HttpVersion != Version11 {
+		return nil
+	}
+
+	for _, value := range r.Headers.Values("expect") {
+		if !strings.EqualFold(value, "100-continue") {
+			return fmt.Errorf("%w: %s", ErrExpectationFailed, value)
+		}
+
+		r.expectContinue = true
+	}
+
+	return nil
+}
+
+// bodyState picks how the body is delimited following RFC 9112, section 6.3.
+// Framing that other servers in a chain could read differently is rejected,
+// as it is what request smuggling builds on.
+func (r *Request) bodyState() (requestState, error) {
+	codings := r.Headers.Values("transfer-encoding")
+	lengths := r.Headers.Values("content-length")
+	if len(codings) > 0 {
+		// a server reading Content-Length instead would see a
+		// different body, the classic request smuggling setup
+		if len(lengths) > 0 {
+			return 0, fmt.Errorf(
+				"%w: both Transfer-Encoding and Content-Length",
+				ErrAmbiguousFraming,
+			)
+		}
+
+		if err := checkTransferCodings(codings); err != nil {
+			return 0, err
+		}
+
+		if r.RequestLine.HttpVersion == Version10 {
+			// HTTP/1.0 has no transfer codings, an intermediary might
+			// have framed the body differently
+			r.closeAfter = true
+		}
+
+		return reqStateParsingChunkSize, nil
+	}
+
+	// no content-length, we assume that there is no body
+	if len(lengths) == 0 {
+		return reqStateDone, nil
+	}
+
+	contentLength := -1
+	for _, value := range lengths {
+		for part := range strings.SplitSeq(value, ",") {
+			n, err := parseContentLength(strings.TrimSpace(part))
+			if err != nil {
+				return 0, err
+			}
+
+			if contentLength >= 0 && (r.strict || n != contentLength) {
+				return 0, fmt.Errorf(
+					"%w: multiple Content-Length values",
+					ErrAmbiguousFraming,
+				)
+			}
+
+			contentLength = n
+		}
+	}
+
+	if r.maxBodyBytes > 0 && contentLength > r.maxBodyBytes {
+		return 0, ErrBodyTooLarge
+	}
+
+	r.contentLength = contentLength
+
+	return reqStateParsingBody, nil
+}
+
+// checkTransferCodings accepts chunked, applied exactly once, as the only
+// transfer coding.
+func checkTransferCodings(values []string) error {
+	chunked := 0
+	for _, value := range values {
+		for coding := range strings.SplitSeq(value, ",") {
+			coding = strings.TrimSpace(coding)
+			switch {
+			case coding == "":
+				// empty list elements are allowed and ignored
+			case strings.EqualFold(coding, "chunked"):
+				chunked++
+			default:
+				return fmt.Errorf("%w: %s", ErrUnsupportedTransferCoding, coding)
+			}
+		}
+	}
+
+	if chunked != 1 {
+		return fmt.Errorf(
+			"%w: chunked has to be applied exactly once",
+			ErrAmbiguousFraming,
+		)
+	}
+
+	return nil
+}
+
+// parseContentLength accepts only the 1*DIGIT form of RFC 9110, so signs and
+// whitespace that strconv.Atoi would let through are rejected.
+func parseContentLength(value string) (int, error) {
+	if value == "" || strings.Trim(value, "0123456789") != "" {
+		return 0, fmt.Errorf("malformed Content-Length: %q", value)
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("malformed Content-Length: %s", err)
+	}
+
+	return n, nil
+}
+
+// maxChunkLineBytes limits the line announcing a chunk, its size and any
+// chunk extensions, which are ignored anyway.
+const maxChunkLineBytes = 4096
+
+// parseChunkSize parses a chunk-size line, ignoring any chunk extensions
+// following the size.
+func parseChunkSize(line string) (int, error) {
+	sizeStr, _, _ := strings.Cut(line, ";")
+	sizeStr = strings.TrimRight(sizeStr, " \t")
+	if sizeStr == "" {
+		return 0, fmt.Errorf("Malformed chunk size: %q", line)
+	}
+
+	size, err := strconv.ParseUint(sizeStr, 16, 31)
+	if err != nil {
+		return 0, fmt.Errorf("Malformed chunk size: %q", line)
+	}
+
+	return int(size), nil
+}
+
+// HTTP versions a request can have in RequestLine.HttpVersion. HTTP/0.9
+// requests consist of a request line without a version and have no headers.
+const (
+	Version09 = "0.9"
+	Version10 = "1.0"
+	Version11 = "1.1"
+)
+
 type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
 	Method        string
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize)
-	readToIdx := 0
+// Reader reads successive requests from a single connection. Bytes read past
+// the end of one request are kept for the next one, so requests pipelined by
+// the client are not lost.
+type Reader struct {
+	// MaxHeaderBytes limits the size of the request line, headers and
+	// trailers, MaxBodyBytes the size of the decoded body. Zero means no
+	// limit.
+	MaxHeaderBytes int
+	MaxBodyBytes   int
+	// StrictFraming rejects requests carrying Content-Length more than
+	// once, even with identical values, which RFC 9112 permits reading.
+	// Requests with both Transfer-Encoding and Content-Length are
+	// rejected regardless. Use it when other servers forward requests to
+	// this one and might frame them differently.
+	StrictFraming bool
+	// ObsFold is the obsolete line folding policy for headers and trailers.
+	// By default folded lines are rejected.
+	ObsFold headers.ObsFoldPolicy
+	// DecodeContent undoes gzip and deflate content codings while the
+	// body is read, so that handlers get the body as it was before the
+	// client compressed it. Other codings fail with
+	// ErrUnsupportedContentCoding. The Content-Encoding and Content-Length
+	// headers of decoded requests are removed. MaxDecodedBodyBytes limits
+	// the size of the decoded body, zero means no limit.
+	DecodeContent       bool
+	MaxDecodedBodyBytes int
+
+	reader    io.Reader
+	buf       []byte
+	readToIdx int
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader: reader,
+		buf:    make([]byte, bufferSize),
+	}
+}
+
+// ReadRequest reads the next request, including its complete body, from the
+// connection. It returns io.EOF when the connection was closed cleanly between
+// requests.
+func (r *Reader) ReadRequest() (*Request, error) {
+	req, err := r.ReadStreamingRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.ReadBody(); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// ReadStreamingRequest reads the request line and headers of the next request
+// and returns without waiting for the body. The body has to be consumed
+// through Request.BodyReader before the next request can be read.
+func (r *Reader) ReadStreamingRequest() (*Request, error) {
 	req := &Request{
-		state:   reqStateInitialized,
-		Headers: headers.NewHeaders(),
+		state:          reqStateInitialized,
+		Headers:        headers.NewHeaders(),
+		Trailers:       headers.NewHeaders(),
+		maxHeaderBytes: r.MaxHeaderBytes,
+		maxBodyBytes:   r.MaxBodyBytes,
+		strict:         r.StrictFraming,
+
+		decodeContent:   r.DecodeContent,
+		maxDecodedBytes: r.MaxDecodedBodyBytes,
+	}
+	req.Headers.ObsFold = r.ObsFold
+	req.Trailers.ObsFold = r.ObsFold
+	err := r.parseUntil(req, func() bool {
+		return req.state > reqStateParsingHeaders
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	for req.state != reqStateDone {
-		if readToIdx == len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
+	req.stream = &bodyReader{rdr: r, req: req}
+
+	return req, nil
+}
+
+// WaitForRequest blocks until the first bytes of the next request have
+// arrived. It returns io.EOF when the connection is closed instead.
+func (r *Reader) WaitForRequest() error {
+	for r.readToIdx == 0 {
+		numBytesRead, err := r.reader.Read(r.buf)
+		r.readToIdx += numBytesRead
+		if err != nil && numBytesRead == 0 {
+			return err
 		}
+	}
+
+	return nil
+}
 
-		numBytesRead, err := reader.Read(buf[readToIdx:])
+// parseUntil parses buffered bytes into req and reads more from the
+// connection until done reports true.
+func (r *Reader) parseUntil(req *Request, done func() bool) error {
+	for {
+		// leftovers of the previous request may already hold this one
+		numBytesParsed, err := req.parse(r.buf[:r.readToIdx])
 		if err != nil {
-			if err == io.EOF {
-				if req.state != reqStateDone {
-					return nil, fmt.Errorf(
-						"incomplete request. State: %d, read n bytes on EOF: %d",
-						req.state,
-						numBytesRead,
-					)
-				}
+			return err
+		}
+
+		copy(r.buf, r.buf[numBytesParsed:r.readToIdx])
+		r.readToIdx -= numBytesParsed
+		if done() {
+			return nil
+		}
+
+		// a single line that never ends counts against the limit too
+		inHeaders := req.state <= reqStateParsingHeaders ||
+			req.state == reqStateParsingTrailers
+		headerBytes := req.headerBytes + r.readToIdx
+		if inHeaders && r.MaxHeaderBytes > 0 && headerBytes > r.MaxHeaderBytes {
+			return ErrHeaderTooLarge
+		}
+
+		if r.readToIdx == len(r.buf) {
+			newBuf := make([]byte, len(r.buf)*2)
+			copy(newBuf, r.buf)
+			r.buf = newBuf
+		}
+
+		numBytesRead, err := r.reader.Read(r.buf[r.readToIdx:])
+		r.readToIdx += numBytesRead
+		if err == io.EOF && numBytesRead > 0 {
+			continue
+		}
 
-				break
+		if err == io.EOF {
+			if req.state == reqStateInitialized && r.readToIdx == 0 {
+				// the peer closed the connection before sending
+				// anything, there is no request to report on
+				return io.EOF
 			}
 
-			return nil, err
+			return fmt.Errorf(
+				"incomplete request. State: %d, unparsed bytes on EOF: %d",
+				req.state,
+				r.readToIdx,
+			)
 		}
-		readToIdx += numBytesRead
 
-		numBytesParsed, err := req.parse(buf[:readToIdx])
 		if err != nil {
-			return nil, err
+			return err
 		}
-
-		copy(buf, buf[numBytesParsed:])
-		readToIdx -= numBytesParsed
 	}
+}
 
-	return req, nil
+// RequestFromReader reads a single request from reader. Use a Reader to read
+// more than one request from the same connection.
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	return NewReader(reader).ReadRequest()
 }
 
 func parseRequestLine(data []byte) (int, *RequestLine, error) {
@@ -177,11 +869,24 @@ func parseRequestLine(data []byte) (int, *RequestLine, error) {
 
 func requestLineFromString(str string) (*RequestLine, error) {
 	parts := strings.Split(str, " ")
+	if len(parts) == 2 && parts[0] == "GET" && strings.HasPrefix(parts[1], "/") {
+		// HTTP/0.9 simple request, no version and no headers
+		return &RequestLine{
+			Method:        parts[0],
+			RequestTarget: parts[1],
+			HttpVersion:   Version09,
+		}, nil
+	}
+
 	if len(parts) != 3 {
 		return nil, fmt.Errorf("Malformed request-line: %s", str)
 	}
 
 	method, target, versionPart := parts[0], parts[1], parts[2]
+	if target == "" {
+		return nil, fmt.Errorf("Malformed request-line: %s", str)
+	}
+
 	method, err := parseHttpMethod(method)
 	if err != nil {
 		return nil, err
@@ -201,6 +906,10 @@ func requestLineFromString(str string) (*RequestLine, error) {
 
 func parseHttpMethod(methodPart string) (string, error) {
 	methodStr := strings.TrimSpace(methodPart)
+	if methodStr == "" {
+		return "", fmt.Errorf("Invalid method: %s", methodPart)
+	}
+
 	for _, char := range methodStr {
 		if char < 'A' || char > 'Z' {
 			return "", fmt.Errorf("Invalid method: %s", methodStr)
@@ -221,9 +930,17 @@ func parseHttpVersion(httpVersionPart string) (string, error) {
 		return "", fmt.Errorf("Unrecognized HTTP-version: %s", httpPart)
 	}
 
-	if versionPart != "1.1" {
+	major, minor, found := strings.Cut(versionPart, ".")
+	isDigit := func(s string) bool {
+		return len(s) == 1 && s[0] >= '0' && s[0] <= '9'
+	}
+	if !found || !isDigit(major) || !isDigit(minor) {
 		return "", fmt.Errorf("Invalid HTTP version: %s", versionPart)
 	}
 
+	if versionPart != Version10 && versionPart != Version11 {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedVersion, versionPart)
+	}
+
 	return versionPart, nil
 }
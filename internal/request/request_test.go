@@ -1,12 +1,19 @@
 package request
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/nordluma/httpfromtcp/internal/headers"
 )
 
 func TestParsesRequestLine(t *testing.T) {
@@ -111,16 +118,23 @@ func TestParseRequestAndStandardHeaders(t *testing.T) {
 	})
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", header(r, "host"))
+	assert.Equal(t, "curl/7.81", header(r, "user-agent"))
+	assert.Equal(t, "*/*", header(r, "accept"))
 }
 
 func TestParseRequestWithEmptyHeaders(t *testing.T) {
-	r, err := RequestFromReader(&chunkReader{
+	// HTTP/1.1 requires a Host header
+	_, err := RequestFromReader(&chunkReader{
 		data:            "GET /coffee HTTP/1.1\r\n\r\n",
 		numBytesPerRead: 5,
 	})
+	require.ErrorIs(t, err, ErrInvalidHost)
+
+	r, err := RequestFromReader(&chunkReader{
+		data:            "GET /coffee HTTP/1.0\r\n\r\n",
+		numBytesPerRead: 5,
+	})
 	require.NoError(t, err)
 	require.NotNil(t, r)
 }
@@ -140,8 +154,9 @@ func TestParseRequestWithMalformedHeader(t *testing.T) {
 func TestParseRequestWithDuplicateHeaders(t *testing.T) {
 	r, err := RequestFromReader(&chunkReader{
 		data: fmt.Sprintf(
-			"%s\r\n%s\r\n%s\r\n\r\n",
+			"%s\r\n%s\r\n%s\r\n%s\r\n\r\n",
 			"GET / HTTP/1.1",
+			"Host: localhost:42069",
 			"Accept-Encoding: gzip",
 			"Accept-Encoding: brotli",
 		),
@@ -149,7 +164,7 @@ func TestParseRequestWithDuplicateHeaders(t *testing.T) {
 	})
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "gzip, brotli", r.Headers["accept-encoding"])
+	assert.Equal(t, []string{"gzip", "brotli"}, r.Headers.Values("accept-encoding"))
 }
 
 func TestParseRequestWithCaseInsensitiveHeaders(t *testing.T) {
@@ -165,9 +180,9 @@ func TestParseRequestWithCaseInsensitiveHeaders(t *testing.T) {
 	})
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", header(r, "host"))
+	assert.Equal(t, "curl/7.81", header(r, "user-agent"))
+	assert.Equal(t, "*/*", header(r, "accept"))
 }
 
 func TestParseRequestMissingEOFHeaders(t *testing.T) {
@@ -193,7 +208,7 @@ func TestParseRequestWithStandardBody(t *testing.T) {
 	})
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "13", r.Headers["content-length"])
+	assert.Equal(t, "13", header(r, "content-length"))
 	assert.Equal(t, body, string(r.Body))
 }
 
@@ -205,7 +220,7 @@ func TestRequestWithEmptyBodyAndContentLength(t *testing.T) {
 	})
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "0", r.Headers["content-length"])
+	assert.Equal(t, "0", header(r, "content-length"))
 	assert.Equal(t, 0, len(r.Body))
 }
 
@@ -232,6 +247,11 @@ func TestRequestWithBodyButNoContentLength(t *testing.T) {
 	assert.Equal(t, 0, len(r.Body))
 }
 
+func header(r *Request, key string) string {
+	value, _ := r.Headers.Get(key)
+	return value
+}
+
 func createRequestWithBody(reqLine, body string) string {
 	return fmt.Sprintf(
 		"%s\r\n%s\r\n%s\r\n%s\r\n%s\r\n\r\n%s",
@@ -270,3 +290,729 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 
 	return n, nil
 }
+
+func TestRequestKeepAlive(t *testing.T) {
+	r, err := RequestFromReader(&chunkReader{
+		data:            createRequest("GET / HTTP/1.1"),
+		numBytesPerRead: 8,
+	})
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	r, err = RequestFromReader(&chunkReader{
+		data: "GET / HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Connection: Close\r\n\r\n",
+		numBytesPerRead: 8,
+	})
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+}
+
+func TestRequestFromClosedConnection(t *testing.T) {
+	_, err := RequestFromReader(&chunkReader{data: "", numBytesPerRead: 1})
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReaderKeepsPipelinedRequests(t *testing.T) {
+	rdr := NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n\r\n" +
+			"hello" +
+			"GET /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n\r\n",
+		numBytesPerRead: 1024,
+	})
+
+	r, err := rdr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(r.Body))
+
+	r, err = rdr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.Equal(t, 0, len(r.Body))
+
+	_, err = rdr.ReadRequest()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestParseChunkedBody(t *testing.T) {
+	r, err := RequestFromReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n\r\n" +
+			"5;name=value\r\nhello\r\n" +
+			"7\r\n world!\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!", string(r.Body))
+	value, found := r.Trailers.Get("X-Checksum")
+	assert.True(t, found)
+	assert.Equal(t, "abc", value)
+}
+
+func TestParseChunkedBodyWithoutTrailers(t *testing.T) {
+	r, err := RequestFromReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n\r\n" +
+			"A\r\n0123456789\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 7,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "0123456789", string(r.Body))
+}
+
+func TestParseChunkedBodyWithInvalidChunkSize(t *testing.T) {
+	_, err := RequestFromReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n\r\n" +
+			"-5\r\nhello\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 7,
+	})
+	require.Error(t, err)
+}
+
+func TestParseChunkedBodyMissingChunkTerminator(t *testing.T) {
+	_, err := RequestFromReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n\r\n" +
+			"5\r\nhello!!\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 7,
+	})
+	require.Error(t, err)
+}
+
+func TestStreamingRequestBody(t *testing.T) {
+	rdr := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n\r\n" +
+			"5\r\nhello\r\n" +
+			"7\r\n world!\r\n" +
+			"0\r\n\r\n" +
+			"GET /next HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+
+	r, err := rdr.ReadStreamingRequest()
+	require.NoError(t, err)
+	assert.Nil(t, r.Body)
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(body))
+
+	r, err = rdr.ReadStreamingRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+}
+
+func TestDiscardUnreadStreamingBody(t *testing.T) {
+	rdr := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n\r\n" +
+			"hello world!\n" +
+			"GET /next HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n\r\n",
+		numBytesPerRead: 5,
+	})
+
+	r, err := rdr.ReadStreamingRequest()
+	require.NoError(t, err)
+	body := r.BodyReader()
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(body, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hel", string(buf))
+	require.NoError(t, body.Close())
+	_, err = body.Read(buf)
+	assert.ErrorIs(t, err, ErrBodyClosed)
+
+	assert.Error(t, r.DiscardBody(4))
+	require.NoError(t, r.DiscardBody(1024))
+
+	r, err = rdr.ReadStreamingRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+}
+
+func TestReaderRejectsOversizedHeaders(t *testing.T) {
+	rdr := NewReader(&chunkReader{
+		data:            createRequest("GET / HTTP/1.1"),
+		numBytesPerRead: 3,
+	})
+	rdr.MaxHeaderBytes = 32
+	_, err := rdr.ReadRequest()
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+}
+
+func TestReaderRejectsOversizedBodies(t *testing.T) {
+	rdr := NewReader(&chunkReader{
+		data:            createRequestWithBody("POST / HTTP/1.1", "hello world!"),
+		numBytesPerRead: 3,
+	})
+	rdr.MaxBodyBytes = 5
+	_, err := rdr.ReadRequest()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	rdr = NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n\r\n" +
+			"3\r\nabc\r\n" +
+			"3\r\ndef\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	rdr.MaxBodyBytes = 5
+	_, err = rdr.ReadRequest()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestReaderLimitsChunkSizeLines(t *testing.T) {
+	prefix := "POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n\r\n"
+
+	// a line that never ends is cut off without waiting for the client
+	rdr := NewReader(&chunkReader{
+		data:            prefix + "1;" + strings.Repeat("a", 64<<10),
+		numBytesPerRead: 1024,
+	})
+	_, err := rdr.ReadRequest()
+	assert.ErrorIs(t, err, ErrChunkLineTooLong)
+	assert.Less(t, len(rdr.buf), 16<<10)
+
+	// as is one that ends too late
+	_, err = RequestFromReader(strings.NewReader(
+		prefix + "1;" + strings.Repeat("a", maxChunkLineBytes) + "\r\nx\r\n0\r\n\r\n",
+	))
+	assert.ErrorIs(t, err, ErrChunkLineTooLong)
+
+	// extensions within the limit are fine
+	r, err := RequestFromReader(strings.NewReader(
+		prefix + "1;name=value\r\nx\r\n0\r\n\r\n",
+	))
+	require.NoError(t, err)
+	assert.Equal(t, "x", string(r.Body))
+}
+
+func TestReaderLimitsTrailers(t *testing.T) {
+	prefix := "POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n\r\n" +
+		"0\r\n"
+
+	rdr := NewReader(&chunkReader{
+		data:            prefix + "X-T: " + strings.Repeat("a", 64<<10),
+		numBytesPerRead: 1024,
+	})
+	rdr.MaxHeaderBytes = 1024
+	_, err := rdr.ReadRequest()
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+	assert.Less(t, len(rdr.buf), 16<<10)
+
+	// complete trailer lines add up as well
+	rdr = NewReader(strings.NewReader(
+		prefix + strings.Repeat("X-T: "+strings.Repeat("a", 100)+"\r\n", 20) + "\r\n",
+	))
+	rdr.MaxHeaderBytes = 1024
+	_, err = rdr.ReadRequest()
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	rdr = NewReader(strings.NewReader(prefix + "X-T: a\r\n\r\n"))
+	rdr.MaxHeaderBytes = 1024
+	r, err := rdr.ReadRequest()
+	require.NoError(t, err)
+	value, _ := r.Trailers.Get("x-t")
+	assert.Equal(t, "a", value)
+}
+
+func TestReaderObsFoldPolicy(t *testing.T) {
+	data := "GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"X-Folded: first\r\n" +
+		"\tsecond\r\n\r\n"
+
+	rdr := NewReader(&chunkReader{data: data, numBytesPerRead: 3})
+	_, err := rdr.ReadRequest()
+	assert.ErrorIs(t, err, headers.ErrObsFold)
+
+	rdr = NewReader(&chunkReader{data: data, numBytesPerRead: 3})
+	rdr.ObsFold = headers.ObsFoldUnfold
+	r, err := rdr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "first second", header(r, "X-Folded"))
+}
+
+func FuzzRequestFromReader(f *testing.F) {
+	f.Add(createRequest("GET / HTTP/1.1"))
+	f.Add(createRequestWithBody("POST /submit HTTP/1.1", "hello world!\n"))
+	f.Add("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"3;ext=1\r\nabc\r\n0\r\nX-Trailer: yes\r\n\r\n")
+	f.Add("GET / HTTP/1.1\r\nno colon\r\n\r\n")
+	f.Add("GET / HTTP/1.1\r\n:\r\n\r\n")
+	f.Add("  / HTTP/1.1\r\n\r\n")
+	f.Add("GET / HTTP/1.1\r\nContent-Length: -1\r\n\r\n")
+
+	f.Fuzz(func(t *testing.T, data string) {
+		r, err := RequestFromReader(strings.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		require.NotNil(t, r)
+		require.NotEmpty(t, r.RequestLine.Method)
+		require.NotEmpty(t, r.RequestLine.RequestTarget)
+		if value, found := r.Headers.Get("Content-Length"); found {
+			if n, err := strconv.Atoi(value); err == nil {
+				require.Len(t, r.Body, n)
+			}
+		}
+	})
+}
+
+func TestMalformedContentLength(t *testing.T) {
+	for _, value := range []string{"-1", "+5", "0x10", "1 2", ""} {
+		_, err := RequestFromReader(strings.NewReader(
+			"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: " + value + "\r\n\r\nhello",
+		))
+		assert.Error(t, err, "Content-Length %q", value)
+	}
+}
+
+func TestFramingConflicts(t *testing.T) {
+	cases := []struct {
+		name    string
+		headers string
+		strict  bool
+		err     error
+	}{
+		{
+			name:    "differing content-length lines",
+			headers: "Content-Length: 3\r\nContent-Length: 4\r\n",
+			err:     ErrAmbiguousFraming,
+		},
+		{
+			name:    "differing content-length list",
+			headers: "Content-Length: 3, 4\r\n",
+			err:     ErrAmbiguousFraming,
+		},
+		{
+			name:    "repeated content-length in strict mode",
+			headers: "Content-Length: 3\r\nContent-Length: 3\r\n",
+			strict:  true,
+			err:     ErrAmbiguousFraming,
+		},
+		{
+			name:    "transfer-encoding with content-length",
+			headers: "Transfer-Encoding: chunked\r\nContent-Length: 3\r\n",
+			err:     ErrAmbiguousFraming,
+		},
+		{
+			name:    "content-length with transfer-encoding",
+			headers: "Content-Length: 3\r\nTransfer-Encoding: chunked\r\n",
+			err:     ErrAmbiguousFraming,
+		},
+		{
+			name:    "transfer-encoding with content-length in strict mode",
+			headers: "Transfer-Encoding: chunked\r\nContent-Length: 3\r\n",
+			strict:  true,
+			err:     ErrAmbiguousFraming,
+		},
+		{
+			name:    "chunked applied twice",
+			headers: "Transfer-Encoding: chunked, chunked\r\n",
+			err:     ErrAmbiguousFraming,
+		},
+		{
+			name:    "chunked not last",
+			headers: "Transfer-Encoding: chunked, gzip\r\n",
+			err:     ErrUnsupportedTransferCoding,
+		},
+		{
+			name:    "unknown coding",
+			headers: "Transfer-Encoding: compress\r\n",
+			err:     ErrUnsupportedTransferCoding,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rdr := NewReader(strings.NewReader(
+				"POST / HTTP/1.1\r\nHost: localhost\r\n" + c.headers + "\r\nabc",
+			))
+			rdr.StrictFraming = c.strict
+			_, err := rdr.ReadRequest()
+			assert.ErrorIs(t, err, c.err)
+		})
+	}
+}
+
+func TestRepeatedContentLengthIsAccepted(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader(
+		"POST / HTTP/1.1\r\nHost: localhost\r\n" +
+			"Content-Length: 3\r\nContent-Length: 3, 3\r\n\r\nabc",
+	))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(r.Body))
+	assert.True(t, r.KeepAlive())
+}
+
+func TestParseRequestTarget(t *testing.T) {
+	cases := []struct {
+		method string
+		target string
+		want   Target
+	}{
+		{
+			method: "GET",
+			target: "/search?q=go&q=http#results",
+			want: Target{
+				Form:     OriginForm,
+				Path:     "/search",
+				RawQuery: "q=go&q=http",
+				Fragment: "results",
+			},
+		},
+		{
+			method: "GET",
+			target: "/a/./b/../c//d%20e/",
+			want:   Target{Form: OriginForm, Path: "/a/c/d e/"},
+		},
+		{
+			method: "GET",
+			target: "/../../etc/passwd",
+			want:   Target{Form: OriginForm, Path: "/etc/passwd"},
+		},
+		{
+			method: "GET",
+			target: "HTTP://example.com:8080?x=1",
+			want: Target{
+				Form:     AbsoluteForm,
+				Scheme:   "http",
+				Host:     "example.com:8080",
+				Path:     "/",
+				RawQuery: "x=1",
+			},
+		},
+		{
+			method: "CONNECT",
+			target: "example.com:443",
+			want:   Target{Form: AuthorityForm, Host: "example.com:443"},
+		},
+		{
+			method: "OPTIONS",
+			target: "*",
+			want:   Target{Form: AsteriskForm},
+		},
+	}
+
+	for _, c := range cases {
+		r, err := RequestFromReader(strings.NewReader(createRequest(
+			fmt.Sprintf("%s %s HTTP/1.1", c.method, c.target),
+		)))
+		require.NoError(t, err, c.target)
+		assert.Equal(t, c.want, r.Target, c.target)
+		assert.Equal(t, c.target, r.RequestLine.RequestTarget)
+	}
+}
+
+func TestInvalidRequestTarget(t *testing.T) {
+	cases := []struct {
+		method string
+		target string
+	}{
+		{method: "GET", target: "/bad%zzescape"},
+		{method: "GET", target: "/caf\xc3\xa9"},
+		{method: "GET", target: "*"},
+		{method: "GET", target: "relative/path"},
+		{method: "GET", target: "mailto:someone@example.com"},
+		{method: "CONNECT", target: "/"},
+		{method: "CONNECT", target: "example.com"},
+	}
+
+	for _, c := range cases {
+		_, err := RequestFromReader(strings.NewReader(createRequest(
+			fmt.Sprintf("%s %s HTTP/1.1", c.method, c.target),
+		)))
+		assert.ErrorIs(t, err, ErrInvalidTarget, "%s %s", c.method, c.target)
+	}
+}
+
+func TestTargetQuery(t *testing.T) {
+	target := Target{RawQuery: "tag=a&tag=b&name=J%C3%B6rg&empty="}
+	query := target.Query()
+	assert.Equal(t, []string{"a", "b"}, query["tag"])
+	assert.Equal(t, "Jörg", query.Get("name"))
+	assert.True(t, query.Has("empty"))
+}
+
+func TestHTTP10Request(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET /health HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, Version10, r.RequestLine.HttpVersion)
+	assert.Equal(t, "/health", r.Target.Path)
+	assert.False(t, r.KeepAlive())
+
+	r, err = RequestFromReader(strings.NewReader(
+		"GET /health HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n",
+	))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	r, err = RequestFromReader(strings.NewReader(
+		"POST / HTTP/1.0\r\nConnection: keep-alive\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"3\r\nabc\r\n0\r\n\r\n",
+	))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(r.Body))
+	assert.False(t, r.KeepAlive())
+}
+
+func TestHTTP09Request(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET /index.html\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, Version09, r.RequestLine.HttpVersion)
+	assert.Equal(t, "/index.html", r.Target.Path)
+	assert.Equal(t, 0, r.Headers.Len())
+	assert.False(t, r.KeepAlive())
+
+	_, err = RequestFromReader(strings.NewReader("POST /index.html\r\n"))
+	assert.Error(t, err)
+}
+
+func TestUnsupportedHttpVersion(t *testing.T) {
+	for _, version := range []string{"2.0", "1.2", "0.9"} {
+		_, err := RequestFromReader(strings.NewReader(createRequest("GET / HTTP/" + version)))
+		assert.ErrorIs(t, err, ErrUnsupportedVersion, version)
+	}
+
+	for _, version := range []string{"1", "1.10", "a.b"} {
+		_, err := RequestFromReader(strings.NewReader(createRequest("GET / HTTP/" + version)))
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrUnsupportedVersion, version)
+	}
+}
+
+func TestHostHeaderIsEnforced(t *testing.T) {
+	cases := []string{
+		"GET / HTTP/1.1\r\nHost: a.example\r\nHost: b.example\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: a.example\r\nHost: a.example\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: a.example, b.example\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: user@a.example\r\n\r\n",
+		"GET / HTTP/1.0\r\nHost: a.example\r\nHost: b.example\r\n\r\n",
+	}
+
+	for _, data := range cases {
+		_, err := RequestFromReader(strings.NewReader(data))
+		assert.ErrorIs(t, err, ErrInvalidHost, data)
+	}
+}
+
+func TestRequestHost(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader(
+		"GET / HTTP/1.1\r\nHost: Example.com:8080\r\n\r\n",
+	))
+	require.NoError(t, err)
+	assert.Equal(t, "Example.com:8080", r.Host())
+
+	// the target of an absolute-form request takes precedence
+	r, err = RequestFromReader(strings.NewReader(
+		"GET http://proxied.example/ HTTP/1.1\r\nHost: example.com\r\n\r\n",
+	))
+	require.NoError(t, err)
+	assert.Equal(t, "proxied.example", r.Host())
+}
+
+func TestExpectContinue(t *testing.T) {
+	// the body only arrives with the second read
+	rdr := NewReader(io.MultiReader(
+		strings.NewReader(
+			"POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-Continue\r\n"+
+				"Content-Length: 5\r\n\r\n",
+		),
+		strings.NewReader("hello"),
+	))
+	r, err := rdr.ReadStreamingRequest()
+	require.NoError(t, err)
+
+	calls := 0
+	r.OnContinue(func() error {
+		calls++
+		return nil
+	})
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, 1, calls)
+}
+
+func TestExpectContinueWithoutBodyRead(t *testing.T) {
+	rdr := NewReader(strings.NewReader(
+		"POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\n" +
+			"Content-Length: 5\r\n\r\n",
+	))
+	r, err := rdr.ReadStreamingRequest()
+	require.NoError(t, err)
+
+	r.OnContinue(func() error {
+		t.Fatal("100 Continue sent for a body that was not read")
+		return nil
+	})
+	assert.ErrorIs(t, r.DiscardBody(1024), ErrBodyNotSent)
+}
+
+func TestUnsupportedExpectation(t *testing.T) {
+	_, err := RequestFromReader(strings.NewReader(
+		"GET / HTTP/1.1\r\nHost: localhost\r\nExpect: something-else\r\n\r\n",
+	))
+	assert.ErrorIs(t, err, ErrExpectationFailed)
+
+	// HTTP/1.0 predates Expect
+	_, err = RequestFromReader(strings.NewReader(
+		"GET / HTTP/1.0\r\nExpect: something-else\r\n\r\n",
+	))
+	assert.NoError(t, err)
+}
+
+func encodeBody(t *testing.T, coding, body string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	var enc io.WriteCloser
+	switch coding {
+	case "gzip":
+		enc = gzip.NewWriter(&buf)
+	case "deflate":
+		enc = zlib.NewWriter(&buf)
+	}
+	_, err := io.WriteString(enc, body)
+	require.NoError(t, err)
+	require.NoError(t, enc.Close())
+
+	return buf.String()
+}
+
+func encodedRequest(contentEncoding, body string) string {
+	return "POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Encoding: " + contentEncoding + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" +
+		body
+}
+
+func TestDecodeContent(t *testing.T) {
+	const body = `{"agent": "a1", "metrics": [1, 2, 3]}`
+	cases := []struct {
+		contentEncoding string
+		encoded         string
+	}{
+		{contentEncoding: "gzip", encoded: encodeBody(t, "gzip", body)},
+		{contentEncoding: "X-Gzip", encoded: encodeBody(t, "gzip", body)},
+		{contentEncoding: "deflate", encoded: encodeBody(t, "deflate", body)},
+		{contentEncoding: "identity", encoded: body},
+		{
+			contentEncoding: "deflate, gzip",
+			encoded:         encodeBody(t, "gzip", encodeBody(t, "deflate", body)),
+		},
+	}
+
+	for _, c := range cases {
+		rdr := NewReader(&chunkReader{
+			data:            encodedRequest(c.contentEncoding, c.encoded),
+			numBytesPerRead: 7,
+		})
+		rdr.DecodeContent = true
+		r, err := rdr.ReadRequest()
+		require.NoError(t, err, c.contentEncoding)
+		assert.Equal(t, body, string(r.Body), c.contentEncoding)
+
+		if c.contentEncoding != "identity" {
+			_, found := r.Headers.Get("content-encoding")
+			assert.False(t, found, c.contentEncoding)
+			_, found = r.Headers.Get("content-length")
+			assert.False(t, found, c.contentEncoding)
+		}
+	}
+}
+
+func TestDecodeContentIsOptIn(t *testing.T) {
+	encoded := encodeBody(t, "gzip", "hello")
+	r, err := RequestFromReader(strings.NewReader(encodedRequest("gzip", encoded)))
+	require.NoError(t, err)
+	assert.Equal(t, encoded, string(r.Body))
+
+	// unknown codings are only a problem when decoding
+	_, err = RequestFromReader(strings.NewReader(encodedRequest("br", "x")))
+	assert.NoError(t, err)
+}
+
+func TestDecodeContentStreaming(t *testing.T) {
+	rdr := NewReader(strings.NewReader(
+		encodedRequest("gzip", encodeBody(t, "gzip", "hello world!")) +
+			"GET /next HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+	))
+	rdr.DecodeContent = true
+	r, err := rdr.ReadStreamingRequest()
+	require.NoError(t, err)
+
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(r.BodyReader(), buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+	// the rest is skipped without being decoded
+	require.NoError(t, r.DiscardBody(1024))
+
+	r, err = rdr.ReadStreamingRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+}
+
+func TestDecodeContentRejectsUnsupportedCodings(t *testing.T) {
+	rdr := NewReader(strings.NewReader(encodedRequest("gzip, br", "x")))
+	rdr.DecodeContent = true
+	_, err := rdr.ReadRequest()
+	assert.ErrorIs(t, err, ErrUnsupportedContentCoding)
+}
+
+func TestDecodeContentLimit(t *testing.T) {
+	// a kilobyte of zeros decodes to a megabyte
+	bomb := encodeBody(t, "gzip", strings.Repeat("\x00", 1<<20))
+	rdr := NewReader(strings.NewReader(encodedRequest("gzip", bomb)))
+	rdr.DecodeContent = true
+	rdr.MaxDecodedBodyBytes = 64 << 10
+	_, err := rdr.ReadRequest()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// a body of exactly the limit is fine
+	rdr = NewReader(strings.NewReader(
+		encodedRequest("gzip", encodeBody(t, "gzip", "hello")),
+	))
+	rdr.DecodeContent = true
+	rdr.MaxDecodedBodyBytes = 5
+	r, err := rdr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+}
+
+func TestDecodeContentWithCorruptBody(t *testing.T) {
+	rdr := NewReader(strings.NewReader(encodedRequest("gzip", "not gzip")))
+	rdr.DecodeContent = true
+	_, err := rdr.ReadRequest()
+	assert.Error(t, err)
+}
@@ -0,0 +1,139 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// TargetForm is one of the request-target forms of RFC 9112, section 3.2.
+type TargetForm int
+
+const (
+	// OriginForm is an absolute path with an optional query, "/index.html?x=1".
+	OriginForm TargetForm = iota
+	// AbsoluteForm is a complete URI, sent to proxies,
+	// "http://example.com/index.html".
+	AbsoluteForm
+	// AuthorityForm is the host and port of a CONNECT request,
+	// "example.com:443".
+	AuthorityForm
+	// AsteriskForm is the "*" of a server-wide OPTIONS request.
+	AsteriskForm
+)
+
+// ErrInvalidTarget is returned for a request-target that does not fit any of
+// the target forms or is not allowed with the request method.
+var ErrInvalidTarget = errors.New("invalid request target")
+
+// Target is the parsed request-target of a request.
+type Target struct {
+	Form TargetForm
+	// Scheme is set for the absolute form, Host for the absolute and
+	// authority forms.
+	Scheme string
+	Host   string
+	// Path is percent-decoded, with dot segments and repeated slashes
+	// removed. A trailing slash is kept. It is empty for the authority and
+	// asterisk forms.
+	Path string
+	// RawQuery is the query without the leading "?", still encoded.
+	RawQuery string
+	// Fragment is not supposed to be sent by clients but is split off when
+	// it is, so that it does not end up in the query.
+	Fragment string
+}
+
+// Query parses RawQuery into its parameters, every name mapping to all of
+// its values in order. Malformed pairs are skipped.
+func (t Target) Query() url.Values {
+	values, _ := url.ParseQuery(t.RawQuery)
+	return values
+}
+
+// parseTarget classifies target and parses it according to its form, which
+// also depends on the request method.
+func parseTarget(method, target string) (Target, error) {
+	for _, char := range []byte(target) {
+		// no whitespace, control characters or raw non-ASCII bytes
+		if char <= 0x20 || char >= 0x7f {
+			return Target{}, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+		}
+	}
+
+	switch {
+	case method == "CONNECT":
+		return parseAuthorityForm(target)
+	case target == "*":
+		if method != "OPTIONS" {
+			return Target{}, fmt.Errorf("%w: * with %s", ErrInvalidTarget, method)
+		}
+
+		return Target{Form: AsteriskForm}, nil
+	case strings.HasPrefix(target, "/"):
+		return parseOriginForm(target)
+	default:
+		return parseAbsoluteForm(target)
+	}
+}
+
+func parseOriginForm(target string) (Target, error) {
+	rest, fragment, _ := strings.Cut(target, "#")
+	rawPath, rawQuery, _ := strings.Cut(rest, "?")
+	decoded, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return Target{}, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+	}
+
+	return Target{
+		Form:     OriginForm,
+		Path:     cleanPath(decoded),
+		RawQuery: rawQuery,
+		Fragment: fragment,
+	}, nil
+}
+
+func parseAbsoluteForm(target string) (Target, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Opaque != "" {
+		return Target{}, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+	}
+
+	return Target{
+		Form:     AbsoluteForm,
+		Scheme:   strings.ToLower(u.Scheme),
+		Host:     u.Host,
+		Path:     cleanPath(u.Path),
+		RawQuery: u.RawQuery,
+		Fragment: u.Fragment,
+	}, nil
+}
+
+func parseAuthorityForm(target string) (Target, error) {
+	host, port, found := strings.Cut(target, ":")
+	isValid := found && host != "" && port != "" &&
+		strings.Trim(port, "0123456789") == "" &&
+		!strings.ContainsAny(target, "/?#@")
+	if !isValid {
+		return Target{}, fmt.Errorf("%w: CONNECT to %q", ErrInvalidTarget, target)
+	}
+
+	return Target{Form: AuthorityForm, Host: target}, nil
+}
+
+// cleanPath removes dot segments and repeated slashes from p, keeping a
+// trailing slash. The result always starts with a slash.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	return cleaned
+}
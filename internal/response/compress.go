@@ -0,0 +1,207 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nordluma/httpfromtcp/internal/headers"
+)
+
+// supportedCodings are the content codings the Writer can compress with, in
+// order of preference. "deflate" is the zlib format, RFC 9110, section
+// 8.4.1.2.
+var supportedCodings = []string{"gzip", "deflate"}
+
+// compressibleTypes are media types outside of text/* and the +json and +xml
+// suffixes that are worth compressing. Images, audio, video and archives
+// are compressed already.
+var compressibleTypes = []string{
+	"application/javascript",
+	"application/json",
+	"application/wasm",
+	"application/xml",
+}
+
+// minCompressSize is the body size below which compressing a body of known
+// length is not worth it.
+const minCompressSize = 256
+
+// NegotiateEncoding picks the content coding to compress a response with
+// from the Accept-Encoding values of a request, weighing them by their
+// q-values as described in RFC 9110, section 12.5.3. It returns an empty
+// string when the response should not be compressed, including when the
+// client did not send Accept-Encoding at all.
+func NegotiateEncoding(acceptEncoding []string) string {
+	weights := map[string]float64{}
+	wildcard := -1.0
+	for _, value := range acceptEncoding {
+		for element := range strings.SplitSeq(value, ",") {
+			coding, params, _ := strings.Cut(element, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" {
+				continue
+			}
+
+			q := parseQValue(params)
+			switch coding {
+			case "*":
+				wildcard = q
+			case "x-gzip":
+				weights["gzip"] = q
+			default:
+				weights[coding] = q
+			}
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range supportedCodings {
+		q, found := weights[coding]
+		if !found {
+			q = wildcard
+		}
+
+		// ties go to the coding listed first in supportedCodings
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best
+}
+
+// parseQValue reads the weight from the parameters following a list element,
+// defaulting to 1. A malformed weight counts as 0.
+func parseQValue(params string) float64 {
+	for param := range strings.SplitSeq(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+
+		return q
+	}
+
+	return 1
+}
+
+// isCompressible reports whether a body of contentType benefits from
+// compression.
+func isCompressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+
+	return slices.Contains(compressibleTypes, mediaType)
+}
+
+// contentCoding decides whether the response with headers h and a body of
+// length bytes, -1 if unknown, gets compressed and returns the coding to
+// use. Responses that could have been compressed are marked with Vary
+// whether they are or not, so that caches keep them apart.
+func (w *Writer) contentCoding(h *headers.Headers, length int) string {
+	if !w.negotiated || w.version == "0.9" || !bodyAllowed(w.statusCode) {
+		return ""
+	}
+
+	contentType, _ := h.Get("content-type")
+	_, isEncoded := h.Get("content-encoding")
+	_, isRange := h.Get("content-range")
+	if !isCompressible(contentType) || isEncoded || isRange ||
+		w.statusCode == PartialContent {
+		return ""
+	}
+
+	if !h.HasToken("vary", "accept-encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+
+	if length >= 0 && length < minCompressSize {
+		return ""
+	}
+
+	return w.coding
+}
+
+// markEncoded updates h for a body compressed with coding. A strong ETag
+// identifies the uncompressed body, so it is weakened.
+func markEncoded(h *headers.Headers, coding string) {
+	h.Set("Content-Encoding", coding)
+	if etag, found := h.Get("etag"); found && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+}
+
+// encoder is what gzip.Writer and zlib.Writer have in common.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func newEncoder(coding string, w io.Writer) (encoder, error) {
+	switch coding {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "deflate":
+		return zlib.NewWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported content coding: %s", coding)
+	}
+}
+
+// compress compresses a complete body with coding.
+func compress(coding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	enc, err := newEncoder(coding, &buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := enc.Write(body); err != nil {
+		return nil, err
+	}
+
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encodedBody receives the output of the encoder and writes it to the
+// connection as body chunks.
+type encodedBody struct {
+	w *Writer
+}
+
+func (b encodedBody) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if b.w.rawChunks {
+		return b.w.writer.Write(p)
+	}
+
+	if _, err := b.w.writeChunk(p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
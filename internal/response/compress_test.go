@@ -0,0 +1,226 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nordluma/httpfromtcp/internal/headers"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := []struct {
+		acceptEncoding []string
+		want           string
+	}{
+		{acceptEncoding: nil, want: ""},
+		{acceptEncoding: []string{""}, want: ""},
+		{acceptEncoding: []string{"gzip"}, want: "gzip"},
+		{acceptEncoding: []string{"x-gzip"}, want: "gzip"},
+		{acceptEncoding: []string{"deflate, gzip"}, want: "gzip"},
+		{acceptEncoding: []string{"gzip;q=0.5, deflate"}, want: "deflate"},
+		{acceptEncoding: []string{"gzip; Q=0.5", "deflate;q=0.8"}, want: "deflate"},
+		{acceptEncoding: []string{"br"}, want: ""},
+		{acceptEncoding: []string{"identity"}, want: ""},
+		{acceptEncoding: []string{"*"}, want: "gzip"},
+		{acceptEncoding: []string{"gzip;q=0, *"}, want: "deflate"},
+		{acceptEncoding: []string{"*;q=0"}, want: ""},
+		{acceptEncoding: []string{"gzip;q=bogus"}, want: ""},
+		{acceptEncoding: []string{"gzip;q=2"}, want: ""},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, NegotiateEncoding(c.acceptEncoding), c.acceptEncoding)
+	}
+}
+
+func TestIsCompressible(t *testing.T) {
+	assert.True(t, isCompressible("text/html; charset=utf-8"))
+	assert.True(t, isCompressible("application/json"))
+	assert.True(t, isCompressible("application/problem+json"))
+	assert.True(t, isCompressible("image/svg+xml"))
+	assert.False(t, isCompressible("video/mp4"))
+	assert.False(t, isCompressible("image/png"))
+	assert.False(t, isCompressible(""))
+}
+
+// readResponse parses a response written by a Writer and returns it with its
+// body still encoded.
+func readResponse(t *testing.T, raw []byte) *http.Response {
+	t.Helper()
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), nil)
+	require.NoError(t, err)
+
+	return res
+}
+
+func decompress(t *testing.T, coding string, r io.Reader) string {
+	t.Helper()
+	var dec io.Reader
+	var err error
+	switch coding {
+	case "gzip":
+		dec, err = gzip.NewReader(r)
+	case "deflate":
+		dec, err = zlib.NewReader(r)
+	}
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(dec)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestCompressBufferedBodyKeepsContentLength(t *testing.T) {
+	body := strings.Repeat("compress me ", 100)
+	for _, coding := range supportedCodings {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.SetCompression(coding)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		_, err := w.Write([]byte(body))
+		require.NoError(t, err)
+		require.NoError(t, w.Finish())
+		assert.True(t, w.KeepAlive())
+
+		res := readResponse(t, buf.Bytes())
+		assert.Equal(t, coding, res.Header.Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+		assert.Equal(t, `W/"v1"`, res.Header.Get("ETag"))
+		assert.Less(t, res.ContentLength, int64(len(body)))
+		assert.Equal(t, body, decompress(t, coding, res.Body))
+	}
+}
+
+func TestCompressStreamedBodyIsChunked(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetCompression("gzip")
+	require.NoError(t, w.WriteStatusLine(Ok))
+	h := GetDefaultHeaders(0)
+	h.Del("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	headerLen := buf.Len()
+	_, err := w.WriteChunkedBody([]byte("first "))
+	require.NoError(t, err)
+	// every chunk written by the handler is flushed through the encoder
+	assert.Greater(t, buf.Len(), headerLen)
+	_, err = w.WriteChunkedBody([]byte("second"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	res := readResponse(t, buf.Bytes())
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "first second", decompress(t, "gzip", res.Body))
+}
+
+func TestCompressReplacesContentLengthPassedToWriteHeaders(t *testing.T) {
+	body := strings.Repeat("<p>hello</p>", 50)
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetCompression("deflate")
+	require.NoError(t, w.WriteStatusLine(Ok))
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/html")
+	h.Set("Content-Length", "600")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteBody([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+
+	res := readResponse(t, buf.Bytes())
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, body, decompress(t, "deflate", res.Body))
+}
+
+func TestCompressLargeBodyForHTTP10(t *testing.T) {
+	body := strings.Repeat("a", autoFrameBufferSize+1)
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetVersion("1.0")
+	w.SetCompression("gzip")
+	_, err := w.Write([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+
+	head, encoded, found := bytes.Cut(buf.Bytes(), []byte("\r\n\r\n"))
+	require.True(t, found)
+	assert.Contains(t, string(head), "Content-Encoding: gzip\r\n")
+	assert.NotContains(t, string(head), "Transfer-Encoding")
+	assert.Equal(t, body, decompress(t, "gzip", bytes.NewReader(encoded)))
+}
+
+func TestCompressSkipsIneligibleResponses(t *testing.T) {
+	large := strings.Repeat("x", 1024)
+	cases := []struct {
+		name     string
+		coding   string
+		status   StatusCode
+		header   map[string]string
+		body     string
+		wantVary bool
+	}{
+		{name: "not negotiated", coding: "", body: large, wantVary: true},
+		{name: "small body", coding: "gzip", body: "tiny", wantVary: true},
+		{
+			name:   "compressed media",
+			coding: "gzip",
+			header: map[string]string{"Content-Type": "video/mp4"},
+			body:   large,
+		},
+		{
+			name:   "already encoded",
+			coding: "gzip",
+			header: map[string]string{"Content-Encoding": "gzip"},
+			body:   large,
+		},
+		{name: "partial content", coding: "gzip", status: PartialContent, body: large},
+		{name: "no content", coding: "gzip", status: NoContent},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.SetCompression(c.coding)
+		if c.status != 0 {
+			require.NoError(t, w.WriteStatusLine(c.status), c.name)
+		}
+		for key, val := range c.header {
+			w.Header().Set(key, val)
+		}
+		_, err := w.Write([]byte(c.body))
+		require.NoError(t, err, c.name)
+		require.NoError(t, w.Finish(), c.name)
+
+		head, body, _ := strings.Cut(buf.String(), "\r\n\r\n")
+		assert.Equal(t, c.body, body, c.name)
+		if _, set := c.header["Content-Encoding"]; !set {
+			assert.NotContains(t, head, "Content-Encoding", c.name)
+		}
+		assert.Equal(t, c.wantVary, strings.Contains(head, "Vary: Accept-Encoding"), c.name)
+	}
+}
+
+func TestWithoutSetCompressionNothingChanges(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	_, err := w.Write([]byte(strings.Repeat("x", 1024)))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.NotContains(t, buf.String(), "Vary")
+	assert.NotContains(t, buf.String(), "Content-Encoding")
+}
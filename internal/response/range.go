@@ -0,0 +1,344 @@
+package response
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nordluma/httpfromtcp/internal/headers"
+)
+
+// TimeFormat is the IMF-fixdate format of HTTP dates, RFC 9110, section
+// 5.6.7.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// ParseTime parses an HTTP date in any of the formats recipients have to
+// accept.
+func ParseTime(value string) (time.Time, error) {
+	var err error
+	for _, layout := range []string{TimeFormat, time.RFC850, time.ANSIC} {
+		var t time.Time
+		if t, err = time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, err
+}
+
+// maxRanges is the number of ranges served in a single response. Requests for
+// more are answered with the whole content, as are ranges adding up to more
+// than the content, rather than letting clients make the server seek back
+// and forth.
+const maxRanges = 16
+
+// ErrUnsatisfiableRange is returned by ParseRange when none of the requested
+// ranges overlaps with the content.
+var ErrUnsatisfiableRange = errors.New("unsatisfiable range")
+
+// ByteRange is the part of some content from Start up to, but excluding,
+// End.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+func (r ByteRange) Length() int64 {
+	return r.End - r.Start
+}
+
+// ContentRange formats r as the value of a Content-Range header for content
+// of size bytes.
+func (r ByteRange) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.End-1, size)
+}
+
+// ParseRange parses a Range header value such as "bytes=0-99,200-,-50" for
+// content of size bytes, RFC 9110, section 14.1.2. Ranges reaching past the
+// end of the content are cut short and ranges starting past it are dropped.
+// It returns no ranges for a value that does not parse, which is to be
+// ignored, and ErrUnsatisfiableRange when no range is left.
+func ParseRange(value string, size int64) ([]ByteRange, error) {
+	unit, set, found := strings.Cut(value, "=")
+	if !found || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, nil
+	}
+
+	var ranges []ByteRange
+	for spec := range strings.SplitSeq(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, nil
+		}
+
+		if first == "" {
+			// suffix range, the last n bytes
+			n, err := parseRangeNumber(last)
+			if err != nil {
+				return nil, nil
+			}
+
+			if n > 0 && size > 0 {
+				ranges = append(ranges, ByteRange{Start: max(size-n, 0), End: size})
+			}
+
+			continue
+		}
+
+		start, err := parseRangeNumber(first)
+		if err != nil {
+			return nil, nil
+		}
+
+		end := size
+		if last != "" {
+			lastPos, err := parseRangeNumber(last)
+			if err != nil || lastPos < start {
+				return nil, nil
+			}
+
+			end = min(lastPos+1, size)
+		}
+
+		if start < size {
+			ranges = append(ranges, ByteRange{Start: start, End: end})
+		}
+	}
+
+	if len(ranges) == 0 {
+		return nil, ErrUnsatisfiableRange
+	}
+
+	return ranges, nil
+}
+
+func parseRangeNumber(s string) (int64, error) {
+	if s == "" || strings.Trim(s, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid range position: %q", s)
+	}
+
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// ServeContent answers a request with content, honouring the Range and
+// If-Range headers among reqHeaders, the headers of the request: the whole
+// content is sent with 200 OK, the requested ranges with 206 Partial Content,
+// as multipart/byteranges if there are several, and ranges lying past the
+// end with 416 Range Not Satisfiable. h holds the headers of the response,
+// its ETag and Last-Modified are what If-Range is compared with. The status
+// line must not have been written yet.
+func ServeContent(
+	w *Writer,
+	reqHeaders *headers.Headers,
+	content io.ReadSeeker,
+	h *headers.Headers,
+) error {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		writeInternalError(w)
+		return err
+	}
+
+	h.Set("Accept-Ranges", "bytes")
+	ranges, err := rangesToServe(reqHeaders, h, size)
+	if err != nil {
+		w.WriteStatusLine(RangeNotSatisfiable)
+		body := []byte("416 Range Not Satisfiable")
+		rh := GetDefaultHeaders(len(body))
+		rh.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		if err := w.WriteHeaders(rh); err != nil {
+			return err
+		}
+
+		_, err := w.WriteBody(body)
+
+		return err
+	}
+
+	switch len(ranges) {
+	case 0:
+		return writeRange(w, h, content, ByteRange{End: size}, size, Ok)
+	case 1:
+		h.Set("Content-Range", ranges[0].ContentRange(size))
+		return writeRange(w, h, content, ranges[0], size, PartialContent)
+	default:
+		return writeMultipartRanges(w, h, content, ranges, size)
+	}
+}
+
+// rangesToServe returns the ranges of content of size bytes requested with
+// the Range header, none for the whole content. A Range header that does
+// not parse or that is tied to another version of the content by If-Range
+// is ignored.
+func rangesToServe(reqHeaders, h *headers.Headers, size int64) ([]ByteRange, error) {
+	value, found := reqHeaders.Get("range")
+	if !found || len(reqHeaders.Values("range")) > 1 {
+		return nil, nil
+	}
+
+	if ifRange, found := reqHeaders.Get("if-range"); found && !ifRangeMatches(ifRange, h) {
+		return nil, nil
+	}
+
+	ranges, err := ParseRange(value, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, r := range ranges {
+		total += r.Length()
+	}
+
+	if len(ranges) > maxRanges || total > size {
+		return nil, nil
+	}
+
+	return ranges, nil
+}
+
+// ifRangeMatches reports whether the If-Range value names the version of the
+// content described by h, which takes a strong match, RFC 9110, section
+// 13.1.5.
+func ifRangeMatches(ifRange string, h *headers.Headers) bool {
+	if strings.HasPrefix(ifRange, `"`) {
+		etag, found := h.Get("etag")
+		return found && ifRange == etag
+	}
+
+	if strings.HasPrefix(ifRange, "W/") {
+		return false
+	}
+
+	lastModified, found := h.Get("last-modified")
+	if !found {
+		return false
+	}
+
+	modTime, err := ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+
+	t, err := ParseTime(ifRange)
+
+	return err == nil && t.Equal(modTime)
+}
+
+// writeRange answers with the range r of content of size bytes.
+func writeRange(
+	w *Writer,
+	h *headers.Headers,
+	content io.ReadSeeker,
+	r ByteRange,
+	size int64,
+	statusCode StatusCode,
+) error {
+	if _, err := content.Seek(r.Start, io.SeekStart); err != nil {
+		writeInternalError(w)
+		return err
+	}
+
+	h.Set("Content-Length", strconv.FormatInt(r.Length(), 10))
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+
+	if w.head {
+		return nil
+	}
+
+	return copyRange(w, content, r.Length())
+}
+
+// writeMultipartRanges answers with several ranges of content as a
+// multipart/byteranges body, RFC 9110, section 14.6. Each part carries the
+// Content-Type from h.
+func writeMultipartRanges(
+	w *Writer,
+	h *headers.Headers,
+	content io.ReadSeeker,
+	ranges []ByteRange,
+	size int64,
+) error {
+	boundary := newBoundary()
+	contentType, _ := h.Get("content-type")
+	partHeaders := make([]string, len(ranges))
+	length := int64(0)
+	for i, r := range ranges {
+		partHeaders[i] = fmt.Sprintf(
+			"\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+			boundary,
+			contentType,
+			r.ContentRange(size),
+		)
+		length += int64(len(partHeaders[i])) + r.Length()
+	}
+	closing := fmt.Sprintf("\r\n--%s--\r\n", boundary)
+	length += int64(len(closing))
+
+	h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	if err := w.WriteStatusLine(PartialContent); err != nil {
+		return err
+	}
+
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+
+	if w.head {
+		return nil
+	}
+
+	for i, r := range ranges {
+		if _, err := io.WriteString(w, partHeaders[i]); err != nil {
+			return err
+		}
+
+		if _, err := content.Seek(r.Start, io.SeekStart); err != nil {
+			return err
+		}
+
+		if err := copyRange(w, content, r.Length()); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, closing)
+
+	return err
+}
+
+// copyRange sends the next n bytes of content through ReadFrom, so that
+// files can go out with sendfile.
+func copyRange(w *Writer, content io.Reader, n int64) error {
+	written, err := w.ReadFrom(io.LimitReader(content, n))
+	if err == nil && written < n {
+		// the content got shorter since its size was taken
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+func newBoundary() string {
+	buf := make([]byte, 16)
+	// crypto/rand.Read never returns an error
+	rand.Read(buf)
+
+	return hex.EncodeToString(buf)
+}
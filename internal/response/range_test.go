@@ -0,0 +1,177 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nordluma/httpfromtcp/internal/headers"
+)
+
+const rangeContent = "0123456789abcdefghij"
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		value string
+		want  []ByteRange
+		err   error
+	}{
+		{value: "bytes=0-4", want: []ByteRange{{Start: 0, End: 5}}},
+		{value: "Bytes = 15-", want: []ByteRange{{Start: 15, End: 20}}},
+		{value: "bytes=-3", want: []ByteRange{{Start: 17, End: 20}}},
+		{value: "bytes=-100", want: []ByteRange{{Start: 0, End: 20}}},
+		{value: "bytes=18-100", want: []ByteRange{{Start: 18, End: 20}}},
+		{
+			value: "bytes=0-1, ,5-6",
+			want:  []ByteRange{{Start: 0, End: 2}, {Start: 5, End: 7}},
+		},
+		{value: "bytes=50-60, 2-3", want: []ByteRange{{Start: 2, End: 4}}},
+		{value: "bytes=20-", err: ErrUnsatisfiableRange},
+		{value: "bytes=-0", err: ErrUnsatisfiableRange},
+		{value: "items=0-4"},
+		{value: "bytes=4-2"},
+		{value: "bytes=a-b"},
+		{value: "bytes=+1-2"},
+		{value: "bytes=5"},
+		{value: "0-4"},
+	}
+
+	for _, c := range cases {
+		ranges, err := ParseRange(c.value, int64(len(rangeContent)))
+		assert.Equal(t, c.err, err, c.value)
+		assert.Equal(t, c.want, ranges, c.value)
+	}
+}
+
+// serveContent serves rangeContent to a request with requestHeaders, from a
+// Writer set up to compress with coding.
+func serveContent(
+	t *testing.T,
+	coding string,
+	requestHeaders ...string,
+) (*http.Response, *Writer) {
+	t.Helper()
+	reqHeaders := headers.NewHeaders()
+	for _, field := range requestHeaders {
+		name, value, _ := strings.Cut(field, ": ")
+		reqHeaders.Add(name, value)
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetCompression(coding)
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/plain")
+	h.Set("ETag", `"v1"`)
+	h.Set("Last-Modified", "Sun, 18 Oct 2026 01:00:00 GMT")
+	require.NoError(t, ServeContent(w, reqHeaders, strings.NewReader(rangeContent), h))
+	require.NoError(t, w.Finish())
+
+	res, err := http.ReadResponse(bufio.NewReader(&buf), nil)
+	require.NoError(t, err)
+
+	return res, w
+}
+
+func readAll(t *testing.T, r io.Reader) string {
+	t.Helper()
+	body, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestServeContent(t *testing.T) {
+	res, _ := serveContent(t, "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "bytes", res.Header.Get("Accept-Ranges"))
+	assert.Equal(t, int64(len(rangeContent)), res.ContentLength)
+	assert.Equal(t, rangeContent, readAll(t, res.Body))
+
+	res, _ = serveContent(t, "", "Range: bytes=-3")
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Equal(t, "bytes 17-19/20", res.Header.Get("Content-Range"))
+	assert.Equal(t, "text/plain", res.Header.Get("Content-Type"))
+	assert.Equal(t, "hij", readAll(t, res.Body))
+}
+
+func TestServeContentMultipleRanges(t *testing.T) {
+	res, _ := serveContent(t, "", "Range: bytes=0-1, 5-6, -2")
+	require.Equal(t, http.StatusPartialContent, res.StatusCode)
+
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	parts := multipart.NewReader(res.Body, params["boundary"])
+	want := []struct{ contentRange, body string }{
+		{"bytes 0-1/20", "01"},
+		{"bytes 5-6/20", "56"},
+		{"bytes 18-19/20", "ij"},
+	}
+	for _, w := range want {
+		part, err := parts.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, w.contentRange, part.Header.Get("Content-Range"))
+		assert.Equal(t, "text/plain", part.Header.Get("Content-Type"))
+		assert.Equal(t, w.body, readAll(t, part))
+	}
+	_, err = parts.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestServeContentInFull(t *testing.T) {
+	for _, header := range []string{
+		"Range: items=0-4",
+		"Range: bytes=a-b",
+		"Range: bytes=0-15, 5-19",
+		"Range: bytes=" + strings.Repeat("0-0,", maxRanges+1),
+	} {
+		res, _ := serveContent(t, "", header)
+		assert.Equal(t, http.StatusOK, res.StatusCode, header)
+		assert.Equal(t, rangeContent, readAll(t, res.Body), header)
+	}
+
+	res, _ := serveContent(t, "", "Range: bytes=0-1", "Range: bytes=2-3")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestServeContentUnsatisfiableRange(t *testing.T) {
+	res, w := serveContent(t, "", "Range: bytes=20-")
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, res.StatusCode)
+	assert.Equal(t, "bytes */20", res.Header.Get("Content-Range"))
+	assert.True(t, w.KeepAlive())
+}
+
+func TestServeContentIfRange(t *testing.T) {
+	cases := []struct {
+		ifRange string
+		status  int
+	}{
+		{ifRange: `"v1"`, status: http.StatusPartialContent},
+		{ifRange: "Sun, 18 Oct 2026 01:00:00 GMT", status: http.StatusPartialContent},
+		{ifRange: `"v0"`, status: http.StatusOK},
+		{ifRange: `W/"v1"`, status: http.StatusOK},
+		{ifRange: "Sat, 17 Oct 2026 01:00:00 GMT", status: http.StatusOK},
+	}
+
+	for _, c := range cases {
+		res, _ := serveContent(t, "", "Range: bytes=0-1", "If-Range: "+c.ifRange)
+		assert.Equal(t, c.status, res.StatusCode, c.ifRange)
+	}
+}
+
+func TestServeContentRangesAreNotCompressed(t *testing.T) {
+	res, _ := serveContent(t, "gzip", "Range: bytes=0-1")
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Empty(t, res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "01", readAll(t, res.Body))
+}
@@ -0,0 +1,349 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nordluma/httpfromtcp/internal/headers"
+)
+
+func TestWriteStatusLine(t *testing.T) {
+	cases := []struct {
+		statusCode StatusCode
+		want       string
+	}{
+		{statusCode: Ok, want: "HTTP/1.1 200 OK\r\n"},
+		{statusCode: NotFound, want: "HTTP/1.1 404 Not Found\r\n"},
+		{statusCode: RangeNotSatisfiable, want: "HTTP/1.1 416 Range Not Satisfiable\r\n"},
+		{statusCode: 299, want: "HTTP/1.1 299 \r\n"},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		require.NoError(t, w.WriteStatusLine(c.statusCode))
+		assert.Equal(t, c.want, buf.String())
+	}
+}
+
+func TestWriteStatusLineWithReason(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLineWithReason(Ok, "Totally Fine"))
+	assert.Equal(t, "HTTP/1.1 200 Totally Fine\r\n", buf.String())
+}
+
+func TestWriteStatusLineRejectsInvalidInput(t *testing.T) {
+	for _, statusCode := range []StatusCode{0, 99, 1000} {
+		w := NewWriter(&bytes.Buffer{})
+		assert.Error(t, w.WriteStatusLine(statusCode))
+	}
+
+	w := NewWriter(&bytes.Buffer{})
+	assert.Error(t, w.WriteStatusLineWithReason(Ok, "OK\r\nX-Injected: yes"))
+}
+
+func TestStatusText(t *testing.T) {
+	assert.Equal(t, "Internal Server Error", StatusText(InternalError))
+	assert.Equal(t, "Early Hints", StatusText(EarlyHints))
+	assert.Equal(t, "", StatusText(299))
+}
+
+func TestWriteHeadersKeepsOrderAndRepeatedFields(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Header().Set("X-Request-Id", "abc")
+	require.NoError(t, w.WriteStatusLine(Ok))
+
+	h := GetDefaultHeaders(0)
+	h.Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+	h.Add("Set-Cookie", "b=2")
+	require.NoError(t, w.WriteHeaders(h))
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 0\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Set-Cookie: a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT\r\n"+
+		"Set-Cookie: b=2\r\n"+
+		"X-Request-Id: abc\r\n"+
+		"\r\n", buf.String())
+}
+
+func TestHTTP10ResponseWithoutChunkedCoding(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetVersion("1.0")
+	require.NoError(t, w.WriteStatusLine(Ok))
+
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	require.NoError(t, w.WriteTrailers(trailers))
+	require.NoError(t, w.Finish())
+
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nConnection: close\r\n\r\nhello", buf.String())
+	assert.False(t, w.KeepAlive())
+}
+
+func TestHTTP10KeepAliveIsAnnounced(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetVersion("1.0")
+	require.NoError(t, w.WriteStatusLine(Ok))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+
+	assert.Contains(t, buf.String(), "Connection: keep-alive\r\n")
+	assert.True(t, w.KeepAlive())
+}
+
+func TestHTTP09ResponseIsBodyOnly(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetVersion("0.9")
+	require.NoError(t, w.WriteStatusLine(Ok))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello", buf.String())
+	assert.False(t, w.KeepAlive())
+}
+
+func TestWriteInformational(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	hints := headers.NewHeaders()
+	hints.Add("Link", "</style.css>; rel=preload; as=style")
+	require.NoError(t, w.WriteInformational(EarlyHints, hints))
+	require.NoError(t, w.WriteInformational(Continue, nil))
+	require.NoError(t, w.WriteStatusLine(NoContent))
+
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\n"+
+		"Link: </style.css>; rel=preload; as=style\r\n"+
+		"\r\n"+
+		"HTTP/1.1 100 Continue\r\n"+
+		"\r\n"+
+		"HTTP/1.1 204 No Content\r\n", buf.String())
+
+	assert.Error(t, w.WriteInformational(Continue, nil))
+}
+
+func TestWriteInformationalRejectsInvalidInput(t *testing.T) {
+	for _, statusCode := range []StatusCode{Ok, SwitchingProtocols, 99} {
+		w := NewWriter(&bytes.Buffer{})
+		assert.Error(t, w.WriteInformational(statusCode, nil), statusCode)
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetVersion("1.0")
+	require.NoError(t, w.WriteInformational(Continue, nil))
+	assert.Empty(t, buf.String())
+}
+
+func TestWriteSmallBodyGetsContentLength(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Header().Set("Content-Type", "text/html")
+	_, err := w.Write([]byte("<p>"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hi</p>"))
+	require.NoError(t, err)
+	// only the status line has been sent
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String())
+	assert.Equal(t, 9, w.BytesWritten())
+
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/html\r\n"+
+		"Content-Length: 9\r\n"+
+		"\r\n"+
+		"<p>hi</p>", buf.String())
+	assert.True(t, w.KeepAlive())
+}
+
+func TestWriteLargeBodySwitchesToChunked(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(Created))
+	body := bytes.Repeat([]byte("a"), autoFrameBufferSize+1)
+	_, err := w.Write(body)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("tail"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	assert.Equal(t, "HTTP/1.1 201 Created\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"\r\n"+
+		fmt.Sprintf("%x\r\n%s\r\n", len(body), body)+
+		"4\r\ntail\r\n"+
+		"0\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+}
+
+func TestWriteWithTrailersIsChunked(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Trailer", "X-Checksum")
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	w.Trailers().Set("X-Checksum", "abc")
+	require.NoError(t, w.Finish())
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Trailer: X-Checksum\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"\r\n"+
+		"5\r\nhello\r\n"+
+		"0\r\n"+
+		"X-Checksum: abc\r\n"+
+		"\r\n", buf.String())
+}
+
+func TestWriteWithContentLengthIsNotBuffered(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Header().Set("Content-Length", "5")
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nhello")))
+
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+}
+
+func TestFlushCommitsToChunked(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	_, err := w.Write([]byte("first"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n5\r\nfirst\r\n")))
+}
+
+func TestFinishWithoutWritesSendsEmptyOk(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Header().Set("X-Request-Id", "abc")
+	require.NoError(t, w.Finish())
+
+	assert.Equal(
+		t,
+		"HTTP/1.1 200 OK\r\nX-Request-Id: abc\r\nContent-Length: 0\r\n\r\n",
+		buf.String(),
+	)
+	assert.True(t, w.KeepAlive())
+}
+
+func TestFinishWithoutBodyForNoContent(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(NoContent))
+	require.NoError(t, w.Finish())
+
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+}
+
+func TestAbortLeavesResponseUnfinished(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	_, err := w.Write([]byte("partial"))
+	require.NoError(t, err)
+	w.Abort()
+	require.NoError(t, w.Finish())
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String())
+	assert.False(t, w.KeepAlive())
+}
+
+func TestHeadResponseHasNoBody(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetMethod("HEAD")
+	_, err := w.Write([]byte("hello body"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	assert.Equal(
+		t,
+		"HTTP/1.1 200 OK\r\nContent-Length: 10\r\nContent-Type: text/plain\r\n\r\n",
+		buf.String(),
+	)
+	assert.True(t, w.KeepAlive())
+
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetMethod("HEAD")
+	w.Header().Set("Transfer-Encoding", "chunked")
+	_, err = w.Write([]byte("hello body"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"), buf.String())
+	assert.NotContains(t, buf.String(), "hello body")
+	assert.True(t, w.KeepAlive())
+}
+
+func TestFailDropsStagedResponse(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetCompression("gzip")
+	w.Header().Set("Transfer-Encoding", "chunked")
+	w.Header().Set("Set-Cookie", "session=abc")
+	w.Trailers().Set("X-Checksum", "abc")
+	w.Fail()
+	require.NoError(t, w.Finish())
+
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n500 Internal Server Error"), out)
+	assert.Contains(t, out, "Content-Length: 25\r\n")
+	assert.NotContains(t, out, "Transfer-Encoding")
+	assert.NotContains(t, out, "Set-Cookie")
+	assert.NotContains(t, out, "Trailer")
+	assert.NotContains(t, out, "gzip")
+	assert.False(t, w.KeepAlive())
+}
+
+func TestWriteRefusesBodyForStatusWithoutBody(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(NoContent))
+	_, err := w.Write([]byte("oops"))
+	assert.ErrorIs(t, err, ErrBodyNotAllowed)
+	_, err = w.ReadFrom(strings.NewReader("oops"))
+	assert.ErrorIs(t, err, ErrBodyNotAllowed)
+	require.NoError(t, w.Finish())
+
+	// nothing follows the header block, not even a Content-Type for it
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(NotModified))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	_, err = w.WriteBody([]byte("oops"))
+	assert.ErrorIs(t, err, ErrBodyNotAllowed)
+	_, err = w.ReadFrom(strings.NewReader("oops"))
+	assert.ErrorIs(t, err, ErrBodyNotAllowed)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\n\r\n", buf.String())
+}
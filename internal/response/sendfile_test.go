@@ -0,0 +1,151 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nordluma/httpfromtcp/internal/headers"
+)
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(tb testing.TB) (*net.TCPConn, *net.TCPConn) {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(tb, err)
+	server, err := ln.Accept()
+	require.NoError(tb, err)
+	tb.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	return server.(*net.TCPConn), client.(*net.TCPConn)
+}
+
+func tempFile(tb testing.TB, size int) *os.File {
+	tb.Helper()
+	name := filepath.Join(tb.TempDir(), "data")
+	data := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+	require.NoError(tb, os.WriteFile(name, data, 0o644))
+
+	f, err := os.Open(name)
+	require.NoError(tb, err)
+	tb.Cleanup(func() { f.Close() })
+
+	return f
+}
+
+func TestWriteFileOverTCP(t *testing.T) {
+	server, client := tcpPair(t)
+	f := tempFile(t, 64<<10)
+	_, err := f.Seek(16, io.SeekStart)
+	require.NoError(t, err)
+
+	go func() {
+		w := NewWriter(server)
+		w.WriteStatusLine(Ok)
+		w.WriteHeaders(GetDefaultHeaders(1000))
+		n, err := w.WriteFile(f, 1000)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1000), n)
+		assert.Equal(t, 1000, w.BytesWritten())
+		assert.NoError(t, w.Finish())
+		assert.True(t, w.KeepAlive())
+	}()
+
+	res, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("0123456789abcdef", 63)[:1000], string(body))
+}
+
+func TestReadFromFallsBackToWrite(t *testing.T) {
+	// chunked bodies need their framing
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(Ok))
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	n, err := w.ReadFrom(strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n5\r\nhello\r\n0\r\n\r\n"))
+
+	// so do bodies framed by Write
+	buf.Reset()
+	w = NewWriter(&buf)
+	_, err = io.Copy(w, strings.NewReader("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "Content-Length: 5\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhello"))
+}
+
+// BenchmarkWriteFile compares sending a file with sendfile through WriteFile
+// to reading it into a buffer and writing that out.
+func BenchmarkWriteFile(b *testing.B) {
+	const size = 16 << 20
+	f := tempFile(b, size)
+
+	cases := []struct {
+		name string
+		send func(w *Writer) error
+	}{
+		{
+			name: "sendfile",
+			send: func(w *Writer) error {
+				_, err := w.WriteFile(f, size)
+				return err
+			},
+		},
+		{
+			name: "buffered",
+			send: func(w *Writer) error {
+				// hides ReadFrom, like handlers calling WriteBody
+				_, err := io.Copy(struct{ io.Writer }{w}, f)
+				return err
+			},
+		},
+	}
+
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			server, client := tcpPair(b)
+			go io.Copy(io.Discard, client)
+
+			b.SetBytes(size)
+			for b.Loop() {
+				if _, err := f.Seek(0, io.SeekStart); err != nil {
+					b.Fatal(err)
+				}
+
+				w := NewWriter(server)
+				w.WriteStatusLine(Ok)
+				h := headers.NewHeaders()
+				h.Set("Content-Length", strconv.Itoa(size))
+				w.WriteHeaders(h)
+				if err := c.send(w); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package response
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"strconv"
 
 	"github.com/nordluma/httpfromtcp/internal/headers"
 )
@@ -14,48 +17,514 @@ const (
 	stateHeaders
 	stateBody
 	stateTrailers
+	stateDone
 )
 
+// ErrBodyNotAllowed is returned when writing body bytes for a response whose
+// status code does not allow a body, 1xx, 204 and 304.
+var ErrBodyNotAllowed = errors.New("response status does not allow a body")
+
+// autoFrameBufferSize is how much of a body written with Write is held back
+// to send it with a Content-Length before switching to chunked coding.
+const autoFrameBufferSize = 8 << 10
+
 type Writer struct {
-	writer io.Writer
-	state  writerState
+	writer   io.Writer
+	state    writerState
+	header   *headers.Headers
+	trailers *headers.Headers
+	version  string
+	// buf holds body bytes passed to Write before the headers are sent
+	buf []byte
+
+	statusCode    StatusCode
+	keepAlive     bool
+	chunked       bool
+	contentLength int
+	bodyWritten   int
+	// rawChunks is set when the handler asked for a chunked body but the
+	// client does not understand chunked coding, so the chunks are written
+	// as they are and the body ends with the connection.
+	rawChunks bool
+	// head is set for responses to HEAD requests, whose body is left out
+	head bool
+
+	// negotiated is set once SetCompression has been called, coding is the
+	// content coding it picked and encoder compresses the body with it
+	negotiated bool
+	coding     string
+	encoder    encoder
 }
 
 func NewWriter(w io.Writer) *Writer {
 	return &Writer{
-		writer: w,
-		state:  stateStatusLine,
+		writer:        w,
+		state:         stateStatusLine,
+		version:       "1.1",
+		keepAlive:     true,
+		contentLength: -1,
+	}
+}
+
+// SetVersion sets the HTTP version of the response to that of the request,
+// "1.1", "1.0" or "0.9". HTTP/1.0 responses are sent without chunked coding,
+// HTTP/0.9 responses consist of the body only.
+func (w *Writer) SetVersion(version string) {
+	w.version = version
+}
+
+// SetMethod tells the Writer the method of the request. Responses to HEAD
+// get the same headers, framing included, as a GET would, but their body is
+// dropped.
+func (w *Writer) SetMethod(method string) {
+	w.head = method == "HEAD"
+}
+
+// SetKeepAlive controls whether the connection may be reused once the
+// response is complete. When disabled, WriteHeaders announces it to the
+// client with a "Connection: close" header.
+func (w *Writer) SetKeepAlive(keepAlive bool) {
+	w.keepAlive = keepAlive
+}
+
+// KeepAlive reports whether the connection can carry another request after
+// this response. It turns false when either side asked for the connection to
+// be closed or when the response was not framed well enough for the client to
+// find its end.
+func (w *Writer) KeepAlive() bool {
+	return w.keepAlive
+}
+
+// Header returns headers that WriteHeaders adds to the ones it is given,
+// letting code wrapping a handler contribute headers to its response. Headers
+// passed to WriteHeaders take precedence.
+func (w *Writer) Header() *headers.Headers {
+	if w.header == nil {
+		w.header = headers.NewHeaders()
+	}
+
+	return w.header
+}
+
+// Trailers returns fields sent after a chunked body once the response is
+// finished. They have to be announced in a Trailer header and are dropped
+// when the body is not chunked.
+func (w *Writer) Trailers() *headers.Headers {
+	if w.trailers == nil {
+		w.trailers = headers.NewHeaders()
+	}
+
+	return w.trailers
+}
+
+// SetCompression lets the body be compressed with coding, "gzip" or
+// "deflate", as negotiated with NegotiateEncoding. An empty coding leaves the
+// body as it is but still marks compressible responses with Vary, as another
+// client may get them compressed. Bodies that are small, already encoded,
+// partial or of a type that does not compress well are sent as they are. A
+// body of unknown length is compressed on the fly and sent chunked.
+func (w *Writer) SetCompression(coding string) {
+	w.negotiated = true
+	w.coding = ""
+	for _, supported := range supportedCodings {
+		if coding == supported {
+			w.coding = coding
+		}
+	}
+}
+
+// StatusCode returns the status code written so far, 0 if the status line
+// has not been written yet.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// BytesWritten returns the number of body bytes written, excluding chunk
+// framing but including bytes Write is still holding back. A compressed body
+// is counted after compression, except for bytes still in the encoder.
+func (w *Writer) BytesWritten() int {
+	return w.bodyWritten + len(w.buf)
+}
+
+// Write writes body bytes and frames the body on its own, so that handlers
+// can set headers through Header and just write. The status line defaults to
+// 200 OK. The headers are held back along with the start of the body: a body
+// that ends within the buffer is sent with a Content-Length, a longer one
+// with chunked coding. A Content-Length or Transfer-Encoding set in Header
+// is used as is, as is the framing passed to WriteHeaders if Write is called
+// after it.
+func (w *Writer) Write(p []byte) (int, error) {
+	switch w.state {
+	case stateStatusLine:
+		if err := w.WriteStatusLine(Ok); err != nil {
+			return 0, err
+		}
+
+		return w.Write(p)
+	case stateHeaders:
+		if err := w.checkBodyAllowed(p); err != nil {
+			return 0, err
+		}
+
+		if w.hasFramingHeader() {
+			if err := w.writeBuffered(false); err != nil {
+				return 0, err
+			}
+
+			return w.Write(p)
+		}
+
+		w.buf = append(w.buf, p...)
+		if len(w.buf) > autoFrameBufferSize {
+			if err := w.writeBuffered(false); err != nil {
+				return 0, err
+			}
+		}
+
+		return len(p), nil
+	case stateBody:
+		if w.encoder != nil {
+			// left to the encoder to gather, Flush pushes it out
+			return w.encoder.Write(p)
+		}
+
+		if w.chunked || w.rawChunks {
+			if _, err := w.WriteChunkedBody(p); err != nil {
+				return 0, err
+			}
+
+			return len(p), nil
+		}
+
+		return w.WriteBody(p)
+	default:
+		return 0, fmt.Errorf("cannot write body in state: %d", w.state)
+	}
+}
+
+// Flush sends the headers and the body held back by Write right away,
+// committing to chunked coding. It is meant for handlers streaming a body
+// whose parts the client should see without delay.
+func (w *Writer) Flush() error {
+	switch {
+	case w.state == stateHeaders:
+		return w.writeBuffered(false)
+	case w.state == stateBody && w.encoder != nil:
+		return w.encoder.Flush()
+	default:
+		return nil
+	}
+}
+
+// Abort gives up on a response that cannot be completed, e.g. because its
+// handler panicked. Body bytes held back by Write are dropped and Finish
+// leaves the response cut short, so the connection gets closed and the
+// client can tell.
+func (w *Writer) Abort() {
+	w.buf = nil
+	w.encoder = nil
+	w.keepAlive = false
+	w.state = stateDone
+}
+
+// Fail ends the response to a request whose handler failed, e.g. by
+// panicking. A response that has not been started yet becomes a 500
+// Internal Server Error, one that has is aborted. The connection is closed
+// either way. Headers, trailers and body bytes staged for the failed response
+// are dropped rather than sent along with the 500.
+func (w *Writer) Fail() {
+	if w.statusCode != 0 {
+		w.Abort()
+		return
+	}
+
+	w.header = nil
+	w.trailers = nil
+	w.buf = nil
+	w.negotiated = false
+	w.coding = ""
+	w.encoder = nil
+	w.SetKeepAlive(false)
+	writeInternalError(w)
+}
+
+func writeInternalError(w *Writer) {
+	w.WriteStatusLine(InternalError)
+	body := []byte("500 Internal Server Error")
+	w.WriteHeaders(GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// checkBodyAllowed fails for body bytes p that would follow the headers of
+// a response that has to end with them.
+func (w *Writer) checkBodyAllowed(p []byte) error {
+	if len(p) > 0 && !bodyAllowed(w.statusCode) {
+		return fmt.Errorf("%w: %d", ErrBodyNotAllowed, w.statusCode)
+	}
+
+	return nil
+}
+
+func (w *Writer) hasFramingHeader() bool {
+	if w.header == nil {
+		return false
+	}
+
+	_, hasLength := w.header.Get("content-length")
+
+	return hasLength || w.header.HasToken("transfer-encoding", "chunked")
+}
+
+// writeBuffered writes the headers set through Header followed by the body
+// held back by Write. Unless final is set more of the body may follow, so it
+// is chunked unless Header already frames it.
+func (w *Writer) writeBuffered(final bool) error {
+	h := headers.NewHeaders()
+	for key, val := range w.Header().All() {
+		h.Add(key, val)
+	}
+
+	_, hasTrailer := h.Get("trailer")
+	switch {
+	case !bodyAllowed(w.statusCode) || w.hasFramingHeader():
+		// framed by the status code or by the handler
+	case final && !hasTrailer:
+		h.Set("Content-Length", strconv.Itoa(len(w.buf)))
+	default:
+		h.Set("Transfer-Encoding", "chunked")
+	}
+
+	_, hasType := h.Get("content-type")
+	if !hasType && bodyAllowed(w.statusCode) && (len(w.buf) > 0 || !final) {
+		h.Set("Content-Type", "text/plain")
+	}
+
+	buffered := w.buf
+	w.buf = nil
+
+	// a body that is complete is compressed as a whole so that it keeps its
+	// Content-Length, WriteHeaders takes care of the rest
+	if _, hasLength := h.Get("content-length"); hasLength && final {
+		if coding := w.contentCoding(h, len(buffered)); coding != "" {
+			compressed, err := compress(coding, buffered)
+			if err != nil {
+				return err
+			}
+
+			markEncoded(h, coding)
+			h.Set("Content-Length", strconv.Itoa(len(compressed)))
+			buffered = compressed
+		}
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+
+	if len(buffered) == 0 {
+		return nil
+	}
+
+	_, err := w.Write(buffered)
+
+	return err
+}
+
+// WriteInformational sends an interim 1xx response, such as 100 Continue or
+// 103 Early Hints, ahead of the final response. It can be called any number
+// of times before WriteStatusLine, h may be nil. HTTP/1.0 and 0.9 clients do
+// not know interim responses, so nothing is sent to them.
+func (w *Writer) WriteInformational(statusCode StatusCode, h *headers.Headers) error {
+	if w.state != stateStatusLine {
+		return fmt.Errorf("cannot write informational response in state: %d", w.state)
+	}
+
+	// 101 hands the connection over to another protocol, which this
+	// writer cannot follow
+	if statusCode < 100 || statusCode > 199 || statusCode == SwitchingProtocols {
+		return fmt.Errorf("Invalid informational status code: %d", statusCode)
+	}
+
+	if w.version != "1.1" {
+		return nil
+	}
+
+	statusLine := getStatusLine(w.version, statusCode, StatusText(statusCode))
+	if _, err := w.writer.Write([]byte(statusLine)); err != nil {
+		return err
+	}
+
+	if h == nil {
+		h = headers.NewHeaders()
 	}
+
+	return w.writeFields(h)
 }
 
+// WriteStatusLine writes the status line with the registered reason phrase
+// of statusCode, which is left empty for unregistered codes.
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineWithReason(statusCode, StatusText(statusCode))
+}
+
+// WriteStatusLineWithReason writes the status line with a custom reason
+// phrase. The status code has to have three digits.
+func (w *Writer) WriteStatusLineWithReason(
+	statusCode StatusCode,
+	reasonPhrase string,
+) error {
 	if w.state != stateStatusLine {
 		return fmt.Errorf("cannot write status line in state: %d", w.state)
 	}
+
+	if err := validateStatusLine(statusCode, reasonPhrase); err != nil {
+		return err
+	}
 	defer func() { w.state = stateHeaders }()
 
-	statusLine := getStatusLine(statusCode)
+	w.statusCode = statusCode
+	if w.version == "0.9" {
+		return nil
+	}
+
+	statusLine := getStatusLine(w.version, statusCode, reasonPhrase)
 	_, err := w.writer.Write([]byte(statusLine))
 
 	return err
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
+func (w *Writer) WriteHeaders(headers *headers.Headers) error {
 	if w.state != stateHeaders {
 		return fmt.Errorf("cannot write headers in state: %d", w.state)
 	}
+
+	if len(w.buf) > 0 {
+		return fmt.Errorf("cannot write headers after the body has been started")
+	}
 	defer func() { w.state = stateBody }()
 
-	for key, val := range headers {
-		header := fmt.Sprintf("%s: %s\r\n", key, val)
-		if _, err := w.writer.Write([]byte(header)); err != nil {
-			return err
+	if w.header != nil {
+		passedIn := map[string]bool{}
+		for key := range w.header.All() {
+			passedIn[key] = len(headers.Values(key)) > 0
+		}
+
+		for key, val := range w.header.All() {
+			if !passedIn[key] {
+				headers.Add(key, val)
+			}
 		}
 	}
 
-	_, err := w.writer.Write([]byte("\r\n"))
+	if err := w.startEncoder(headers); err != nil {
+		return err
+	}
 
-	return err
+	w.chunked = headers.HasToken("transfer-encoding", "chunked")
+	if w.chunked && w.version != "1.1" {
+		headers.Del("Transfer-Encoding")
+		headers.Del("Trailer")
+		w.chunked = false
+		w.rawChunks = true
+	}
+
+	if value, found := headers.Get("content-length"); found && !w.chunked {
+		if n, err := strconv.Atoi(value); err == nil {
+			w.contentLength = n
+		}
+	}
+
+	if !bodyAllowed(w.statusCode) {
+		w.contentLength = 0
+	}
+
+	// without a length or chunked framing the body ends when the
+	// connection does
+	if !w.chunked && w.contentLength < 0 {
+		w.keepAlive = false
+	}
+
+	if headers.HasToken("connection", "close") || w.version == "0.9" {
+		w.keepAlive = false
+	}
+
+	switch {
+	case w.version == "0.9":
+		return nil
+	case !w.keepAlive:
+		headers.Set("Connection", "close")
+	case w.version == "1.0":
+		// HTTP/1.0 connections close unless both sides say otherwise
+		headers.Set("Connection", "keep-alive")
+	}
+
+	if err := w.writeFields(headers); err != nil {
+		return err
+	}
+
+	if w.head {
+		// the framing headers describe the body a GET would get, which
+		// is not sent
+		w.writer = io.Discard
+	}
+
+	return nil
+}
+
+// ReadFrom copies the body from r until EOF, making the Writer an
+// io.ReaderFrom for io.Copy. A body sent as it is, neither chunked nor
+// compressed, is handed to the ReadFrom of the connection, which for a
+// *net.TCPConn and an *os.File, or an io.LimitedReader around one, has the
+// kernel send the file with sendfile instead of copying it through user
+// space. Anything else goes through Write.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	rf, isReaderFrom := w.writer.(io.ReaderFrom)
+	isPlain := w.state == stateBody && !w.chunked && w.encoder == nil
+	// Write turns down bodies the status code does not allow
+	if !isPlain || !isReaderFrom || !bodyAllowed(w.statusCode) {
+		// hide ReadFrom from io.Copy, it would call right back here
+		return io.Copy(struct{ io.Writer }{w}, r)
+	}
+
+	n, err := rf.ReadFrom(r)
+	w.bodyWritten += int(n)
+
+	return n, err
+}
+
+// WriteFile writes n bytes of f, starting at its current offset, as the body.
+// It takes the path through sendfile that ReadFrom describes.
+func (w *Writer) WriteFile(f *os.File, n int64) (int64, error) {
+	return w.ReadFrom(io.LimitReader(f, n))
+}
+
+// startEncoder switches a response that gets compressed on the fly to
+// chunked coding, as its length is not known up front.
+func (w *Writer) startEncoder(h *headers.Headers) error {
+	length := -1
+	if value, found := h.Get("content-length"); found {
+		if n, err := strconv.Atoi(value); err == nil {
+			length = n
+		}
+	}
+
+	coding := w.contentCoding(h, length)
+	if coding == "" {
+		return nil
+	}
+
+	enc, err := newEncoder(coding, encodedBody{w: w})
+	if err != nil {
+		return err
+	}
+
+	markEncoded(h, coding)
+	h.Del("Content-Length")
+	if !h.HasToken("transfer-encoding", "chunked") {
+		h.Add("Transfer-Encoding", "chunked")
+	}
+	w.encoder = enc
+
+	return nil
 }
 
 func (w *Writer) WriteBody(p []byte) (int, error) {
@@ -63,14 +532,55 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 		return 0, fmt.Errorf("cannot write body in state: %d", w.state)
 	}
 
-	return w.writer.Write(p)
+	if err := w.checkBodyAllowed(p); err != nil {
+		return 0, err
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(p)
+	}
+
+	n, err := w.writer.Write(p)
+	w.bodyWritten += n
+
+	return n, err
 }
 
+// WriteChunkedBody writes p as a single chunk, or unframed if the client
+// cannot decode chunks. When the body is compressed, p is compressed and
+// flushed out as one chunk.
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if w.state != stateBody {
 		return 0, fmt.Errorf("cannot write body in state: %d", w.state)
 	}
 
+	if err := w.checkBodyAllowed(p); err != nil {
+		return 0, err
+	}
+
+	if w.encoder != nil {
+		n, err := w.encoder.Write(p)
+		if err != nil {
+			return n, err
+		}
+
+		return n, w.encoder.Flush()
+	}
+
+	if w.rawChunks {
+		return w.WriteBody(p)
+	}
+
+	return w.writeChunk(p)
+}
+
+// writeChunk frames p as a chunk.
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	// an empty chunk would end the body
+	if len(p) == 0 {
+		return 0, nil
+	}
+
 	chunkSize := len(p)
 	total := 0
 	n, err := fmt.Fprintf(w.writer, "%x\r\n", chunkSize)
@@ -80,6 +590,7 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	total += n
 
 	n, err = w.writer.Write(p)
+	w.bodyWritten += n
 	if err != nil {
 		return total, err
 	}
@@ -100,6 +611,18 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	}
 	defer func() { w.state = stateTrailers }()
 
+	if w.encoder != nil {
+		enc := w.encoder
+		w.encoder = nil
+		if err := enc.Close(); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.rawChunks {
+		return 0, nil
+	}
+
 	n, err := w.writer.Write([]byte("0\r\n"))
 	if err != nil {
 		return n, err
@@ -108,15 +631,26 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	return n, nil
 }
 
-func (w *Writer) WriteTrailers(h headers.Headers) error {
+func (w *Writer) WriteTrailers(h *headers.Headers) error {
 	if w.state != stateTrailers {
 		return fmt.Errorf("cannot write trailers in state: %d", w.state)
 	}
-	defer func() { w.state = stateBody }()
+	defer func() { w.state = stateDone }()
+
+	if w.rawChunks {
+		// there is nowhere to put trailers without chunked coding
+		return nil
+	}
+
+	return w.writeFields(h)
+}
 
-	for k, v := range h {
-		header := fmt.Sprintf("%s: %s\r\n", k, v)
-		if _, err := w.writer.Write([]byte(header)); err != nil {
+// writeFields writes the field lines of h followed by the empty line ending
+// the field section.
+func (w *Writer) writeFields(h *headers.Headers) error {
+	for key, val := range h.All() {
+		field := fmt.Sprintf("%s: %s\r\n", key, val)
+		if _, err := w.writer.Write([]byte(field)); err != nil {
 			return err
 		}
 	}
@@ -125,3 +659,48 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 
 	return err
 }
+
+// Finish completes the response after the handler has returned. A handler
+// that wrote nothing gets 200 OK with an empty body. Headers and body held
+// back by Write are sent, a chunked body that was left open is terminated
+// along with its trailers, and any response the client cannot delimit on its
+// own marks the connection for closing.
+func (w *Writer) Finish() error {
+	switch w.state {
+	case stateStatusLine:
+		// a handler that wrote nothing answers with an empty 200 OK
+		if err := w.WriteStatusLine(Ok); err != nil {
+			w.keepAlive = false
+			return err
+		}
+
+		return w.Finish()
+	case stateHeaders:
+		if err := w.writeBuffered(true); err != nil {
+			w.keepAlive = false
+			return err
+		}
+
+		return w.Finish()
+	case stateBody:
+		if w.chunked || w.rawChunks {
+			if _, err := w.WriteChunkedBodyDone(); err != nil {
+				w.keepAlive = false
+				return err
+			}
+
+			return w.Finish()
+		}
+
+		if w.contentLength != w.bodyWritten && !w.head {
+			w.keepAlive = false
+		}
+	case stateTrailers:
+		if err := w.WriteTrailers(w.Trailers()); err != nil {
+			w.keepAlive = false
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+package router
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/nordluma/httpfromtcp/internal/request"
+	"github.com/nordluma/httpfromtcp/internal/response"
+	"github.com/nordluma/httpfromtcp/internal/server"
+)
+
+// segmentKind orders the kinds of pattern segments by how specific they are,
+// a route matching with more specific segments wins over the others.
+type segmentKind int
+
+const (
+	segmentWildcard segmentKind = iota
+	segmentParam
+	segmentLiteral
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+type route struct {
+	method   string
+	segments []segment
+	handler  server.Handler
+}
+
+// Router dispatches requests to handlers by method and path. Patterns are
+// made of slash separated segments, each of which is either a literal, a
+// "{name}" parameter matching a single segment or a trailing "*" matching the
+// rest of the path. Captured values are available from
+// request.Request.PathValue, the rest of the path under the name "*".
+type Router struct {
+	routes []*route
+
+	// NotFound is called when no route matches the request path.
+	NotFound server.Handler
+}
+
+func New() *Router {
+	return &Router{
+		NotFound: notFound,
+	}
+}
+
+// Handle registers handler for requests with the given method whose path
+// matches pattern. An empty method matches every method.
+func (rt *Router) Handle(method, pattern string, handler server.Handler) {
+	rt.routes = append(rt.routes, &route{
+		method:   method,
+		segments: parsePattern(pattern),
+		handler:  handler,
+	})
+}
+
+func (rt *Router) Get(pattern string, handler server.Handler) {
+	rt.Handle("GET", pattern, handler)
+}
+
+func (rt *Router) Post(pattern string, handler server.Handler) {
+	rt.Handle("POST", pattern, handler)
+}
+
+func (rt *Router) Put(pattern string, handler server.Handler) {
+	rt.Handle("PUT", pattern, handler)
+}
+
+func (rt *Router) Patch(pattern string, handler server.Handler) {
+	rt.Handle("PATCH", pattern, handler)
+}
+
+func (rt *Router) Delete(pattern string, handler server.Handler) {
+	rt.Handle("DELETE", pattern, handler)
+}
+
+// Serve is a server.Handler dispatching to the most specific matching route
+// by the decoded and cleaned request path. Requests whose path matches only
+// routes for other methods are answered with 405 Method Not Allowed. HEAD
+// requests are dispatched to GET routes.
+func (rt *Router) Serve(w *response.Writer, req *request.Request) {
+	// authority and asterisk form targets have no path to route by
+	if req.Target.Path == "" {
+		rt.NotFound(w, req)
+		return
+	}
+
+	parts := splitPath(req.Target.Path)
+
+	var best *route
+	var bestValues map[string]string
+	allowed := []string{}
+	for _, r := range rt.routes {
+		values, ok := r.match(parts)
+		if !ok {
+			continue
+		}
+
+		if !r.allows(req.RequestLine.Method) {
+			allowed = append(allowed, r.method)
+			if r.method == "GET" {
+				allowed = append(allowed, "HEAD")
+			}
+			continue
+		}
+
+		if best == nil || r.moreSpecificThan(best) {
+			best, bestValues = r, values
+		}
+	}
+
+	if best == nil {
+		if len(allowed) > 0 {
+			slices.Sort(allowed)
+			methodNotAllowed(w, slices.Compact(allowed))
+			return
+		}
+
+		rt.NotFound(w, req)
+		return
+	}
+
+	for name, value := range bestValues {
+		req.SetPathValue(name, value)
+	}
+
+	best.handler(w, req)
+}
+
+// allows reports whether the route serves method. GET routes answer HEAD as
+// well, the Writer leaves out the body.
+func (r *route) allows(method string) bool {
+	return r.method == "" || r.method == method || (r.method == "GET" && method == "HEAD")
+}
+
+func (r *route) match(parts []string) (map[string]string, bool) {
+	values := map[string]string{}
+	for i, seg := range r.segments {
+		if seg.kind == segmentWildcard {
+			values["*"] = strings.Join(parts[min(i, len(parts)):], "/")
+			return values, true
+		}
+
+		if i >= len(parts) {
+			return nil, false
+		}
+
+		switch seg.kind {
+		case segmentLiteral:
+			if parts[i] != seg.value {
+				return nil, false
+			}
+		case segmentParam:
+			if parts[i] == "" {
+				return nil, false
+			}
+
+			values[seg.value] = parts[i]
+		}
+	}
+
+	if len(parts) != len(r.segments) {
+		return nil, false
+	}
+
+	return values, true
+}
+
+func (r *route) moreSpecificThan(other *route) bool {
+	for i := range min(len(r.segments), len(other.segments)) {
+		if r.segments[i].kind != other.segments[i].kind {
+			return r.segments[i].kind > other.segments[i].kind
+		}
+	}
+
+	if len(r.segments) != len(other.segments) {
+		return len(r.segments) > len(other.segments)
+	}
+
+	// a route for a single method beats one for all methods
+	return r.method != "" && other.method == ""
+}
+
+func parsePattern(pattern string) []segment {
+	parts := splitPath(pattern)
+	segments := make([]segment, 0, len(parts))
+	for i, part := range parts {
+		isLast := i == len(parts)-1
+		switch {
+		case part == "*" && isLast:
+			segments = append(segments, segment{kind: segmentWildcard})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := part[1 : len(part)-1]
+			segments = append(segments, segment{kind: segmentParam, value: name})
+		default:
+			segments = append(segments, segment{kind: segmentLiteral, value: part})
+		}
+	}
+
+	return segments
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+func notFound(w *response.Writer, _ *request.Request) {
+	w.WriteStatusLine(response.NotFound)
+	body := []byte("404 Not Found")
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func methodNotAllowed(w *response.Writer, allowed []string) {
+	w.WriteStatusLine(response.MethodNotAllowed)
+	body := []byte("405 Method Not Allowed")
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Allow", strings.Join(allowed, ", "))
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
@@ -0,0 +1,89 @@
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nordluma/httpfromtcp/internal/request"
+	"github.com/nordluma/httpfromtcp/internal/response"
+	"github.com/nordluma/httpfromtcp/internal/server"
+)
+
+func namedHandler(name string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		body := fmt.Appendf(nil, "%s id=%s rest=%s", name, req.PathValue("id"), req.PathValue("*"))
+		w.WriteStatusLine(response.Ok)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+}
+
+func serve(t *testing.T, rt *Router, method, target string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(bytes.NewBufferString(
+		fmt.Sprintf("%s %s HTTP/1.1\r\nHost: localhost\r\n\r\n", method, target),
+	))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	rt.Serve(response.NewWriter(&buf), req)
+
+	return buf.String()
+}
+
+func TestRouterMatchesMethodAndPath(t *testing.T) {
+	rt := New()
+	rt.Get("/", namedHandler("root"))
+	rt.Get("/users/{id}", namedHandler("get-user"))
+	rt.Delete("/users/{id}", namedHandler("delete-user"))
+	rt.Get("/users/me", namedHandler("me"))
+	rt.Get("/static/*", namedHandler("static"))
+	rt.Handle("", "/any", namedHandler("any"))
+
+	cases := []struct {
+		method string
+		target string
+		want   string
+	}{
+		{method: "GET", target: "/", want: "root id= rest="},
+		{method: "GET", target: "/users/42", want: "get-user id=42 rest="},
+		{method: "GET", target: "/users/42?verbose=1", want: "get-user id=42 rest="},
+		{method: "DELETE", target: "/users/42", want: "delete-user id=42 rest="},
+		{method: "GET", target: "/users/me", want: "me id= rest="},
+		{method: "GET", target: "/static/css/site.css", want: "static id= rest=css/site.css"},
+		{method: "PUT", target: "/any", want: "any id= rest="},
+		{method: "GET", target: "/users/%34%32", want: "get-user id=42 rest="},
+		{method: "GET", target: "/static/../users/me", want: "me id= rest="},
+		{method: "GET", target: "http://localhost/users/7", want: "get-user id=7 rest="},
+	}
+
+	for _, c := range cases {
+		out := serve(t, rt, c.method, c.target)
+		assert.Contains(t, out, "HTTP/1.1 200 OK")
+		assert.Contains(t, out, "\r\n\r\n"+c.want, "%s %s", c.method, c.target)
+	}
+}
+
+func TestRouterAnswersNotFound(t *testing.T) {
+	rt := New()
+	rt.Get("/users/{id}", namedHandler("get-user"))
+
+	assert.Contains(t, serve(t, rt, "GET", "/users"), "HTTP/1.1 404 Not Found")
+	assert.Contains(t, serve(t, rt, "GET", "/users/1/posts"), "HTTP/1.1 404 Not Found")
+	assert.Contains(t, serve(t, rt, "OPTIONS", "*"), "HTTP/1.1 404 Not Found")
+}
+
+func TestRouterAnswersMethodNotAllowed(t *testing.T) {
+	rt := New()
+	rt.Get("/users/{id}", namedHandler("get-user"))
+	rt.Put("/users/{id}", namedHandler("put-user"))
+	rt.Delete("/users/{id}", namedHandler("delete-user"))
+
+	out := serve(t, rt, "POST", "/users/1")
+	assert.Contains(t, out, "HTTP/1.1 405 Method Not Allowed")
+	assert.Contains(t, out, "Allow: DELETE, GET, HEAD, PUT\r\n")
+}
@@ -1,39 +1,183 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"runtime/debug"
+	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/nordluma/httpfromtcp/internal/headers"
 	"github.com/nordluma/httpfromtcp/internal/request"
 	"github.com/nordluma/httpfromtcp/internal/response"
 )
 
 type Handler func(w *response.Writer, req *request.Request)
 
+// Config holds the settings of a Server. The zero value listens on
+// 127.0.0.1 without any timeouts or size limits.
+type Config struct {
+	// Host is the address to bind to, e.g. "0.0.0.0", "::" or "::1".
+	// Defaults to 127.0.0.1.
+	Host string
+
+	// ReadHeaderTimeout bounds reading the request line and headers,
+	// ReadBodyTimeout reading the body. A client that is too slow is
+	// answered with 408 Request Timeout.
+	ReadHeaderTimeout time.Duration
+	ReadBodyTimeout   time.Duration
+	// WriteTimeout bounds running the handler and writing the response.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a kept-alive connection may wait for its
+	// next request. Defaults to ReadHeaderTimeout.
+	IdleTimeout time.Duration
+
+	// MaxHeaderBytes limits the request line, headers and trailers,
+	// answered with 431 Request Header Fields Too Large. MaxBodyBytes
+	// limits the body, answered with 413 Content Too Large. Zero means no
+	// limit.
+	MaxHeaderBytes int
+	MaxBodyBytes   int
+
+	// StrictFraming additionally rejects requests repeating an identical
+	// Content-Length, see request.Reader.StrictFraming. Requests with both
+	// Transfer-Encoding and Content-Length are always rejected.
+	StrictFraming bool
+	// ObsFold decides whether header lines folded with the obsolete line
+	// folding syntax are rejected with 400 Bad Request, the default, or
+	// unfolded into a single space.
+	ObsFold headers.ObsFoldPolicy
+	// DecodeRequestBodies hands handlers gzip and deflate encoded request
+	// bodies decoded, bodies with other content codings are answered with
+	// 415 Unsupported Media Type. MaxDecodedBodyBytes limits the decoded
+	// body, answered with 413 Content Too Large. Zero means no limit,
+	// which leaves the server open to small bodies decoding to gigabytes.
+	DecodeRequestBodies bool
+	MaxDecodedBodyBytes int
+
+	// PipelineDepth is the number of requests read ahead on a connection
+	// while earlier responses are still being produced, 0 serves requests
+	// one at a time.
+	PipelineDepth int
+	// StreamBodies hands requests to the handler as soon as their headers
+	// are parsed, leaving the body to be read from Request.BodyReader.
+	StreamBodies bool
+
+	// TLSConfig makes the server speak HTTPS when set.
+	TLSConfig *tls.Config
+}
+
 type Server struct {
 	listener net.Listener
 	handler  Handler
+	cfg      Config
 
 	closed atomic.Bool
+
+	mu    sync.Mutex
+	conns map[net.Conn]*connState
+}
+
+// connState tracks what a connection is doing so that Shutdown can tell
+// idle connections from ones with requests in flight.
+type connState struct {
+	// waiting is set while the connection waits for its next request,
+	// inFlight counts requests read but not answered yet.
+	waiting  atomic.Bool
+	inFlight atomic.Int32
+}
+
+func (c *connState) idle() bool {
+	return c.waiting.Load() && c.inFlight.Load() == 0
+}
+
+// maxDrainBytes is how much of a streamed body the server reads past on
+// behalf of a handler before giving up on reusing the connection.
+const maxDrainBytes = 256 << 10
+
+// shutdownPollInterval is how often Shutdown checks whether the active
+// connections have finished.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// lingerTimeout bounds how long a connection is drained before closing it
+// after an error response.
+const lingerTimeout = 500 * time.Millisecond
+
+// Option configures a Server before it starts accepting connections.
+type Option func(*Server)
+
+// WithConfig replaces the whole configuration of the server. Options passed
+// after it still apply on top.
+func WithConfig(cfg Config) Option {
+	return func(s *Server) {
+		s.cfg = cfg
+	}
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+// WithPipelining lets handlers for up to depth pipelined requests on the same
+// connection run concurrently. Responses are buffered and written back in the
+// order the requests arrived, so handlers must not rely on streaming their
+// output to the client.
+func WithPipelining(depth int) Option {
+	return func(s *Server) {
+		s.cfg.PipelineDepth = depth
+	}
+}
+
+// WithStreamingBodies calls the handler right after the request headers have
+// been parsed instead of buffering the whole body first. Handlers read the
+// body from Request.BodyReader, whatever they leave unread is discarded
+// before the next request on the connection. Pipelined connections always
+// buffer bodies.
+func WithStreamingBodies() Option {
+	return func(s *Server) {
+		s.cfg.StreamBodies = true
+	}
+}
+
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
+	s := &Server{
+		handler: handler,
+		conns:   make(map[net.Conn]*connState),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	host := s.cfg.Host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
 	if err != nil {
 		return nil, err
 	}
 
-	s := &Server{
-		listener: listener,
-		handler:  handler,
+	if s.cfg.TLSConfig != nil {
+		listener = tls.NewListener(listener, s.cfg.TLSConfig)
 	}
+	s.listener = listener
 
 	go s.listen()
 
 	return s, nil
 }
 
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops accepting new connections. Connections that are already open
+// are left alone, use Shutdown to wait for them.
 func (s *Server) Close() error {
 	s.closed.Store(true)
 	if s.listener != nil {
@@ -43,6 +187,80 @@ func (s *Server) Close() error {
 	return nil
 }
 
+// Shutdown stops accepting new connections, closes idle ones and waits for
+// active connections to finish their current response. When ctx expires
+// first, the remaining connections are closed forcefully. It returns the
+// number of connections that were cut off.
+func (s *Server) Shutdown(ctx context.Context) (int, error) {
+	if err := s.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return 0, err
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() == 0 {
+			return 0, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return s.closeAllConns(), ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdleConns closes connections waiting for a request and returns how
+// many connections are still busy.
+func (s *Server) closeIdleConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	active := 0
+	for conn, state := range s.conns {
+		if state.idle() {
+			conn.Close()
+			delete(s.conns, conn)
+			continue
+		}
+
+		active++
+	}
+
+	return active
+}
+
+func (s *Server) closeAllConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.conns)
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+
+	return n
+}
+
+func (s *Server) trackConn(conn net.Conn) *connState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := &connState{}
+	s.conns[conn] = state
+
+	return state
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+}
+
 func (s *Server) listen() {
 	for {
 		conn, err := s.listener.Accept()
@@ -56,21 +274,322 @@ func (s *Server) listen() {
 		}
 		fmt.Printf("Connection accepted: %s\n", conn.RemoteAddr())
 
-		go s.handle(conn)
+		// tracked before handle runs, so that Shutdown cannot miss it
+		state := s.trackConn(conn)
+		go s.handle(conn, state)
 	}
 }
 
-func (s *Server) handle(conn net.Conn) {
+// handle serves requests on conn until either side asks for the connection to
+// be closed, the client goes away or a request cannot be parsed.
+func (s *Server) handle(conn net.Conn, state *connState) {
+	defer s.untrackConn(conn)
 	defer conn.Close()
-	w := response.NewWriter(conn)
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		w.WriteStatusLine(response.BadRequest)
-		body := fmt.Appendf(nil, "error parsing request: %v", err)
-		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
-		w.WriteBody(body)
+	defer func() {
+		// last line of defence, a panic must not take the process down
+		if err := recover(); err != nil {
+			fmt.Printf(
+				"panic serving %s: %v\n%s",
+				conn.RemoteAddr(),
+				err,
+				debug.Stack(),
+			)
+		}
+	}()
+	rdr := request.NewReader(conn)
+	rdr.MaxHeaderBytes = s.cfg.MaxHeaderBytes
+	rdr.MaxBodyBytes = s.cfg.MaxBodyBytes
+	rdr.StrictFraming = s.cfg.StrictFraming
+	rdr.ObsFold = s.cfg.ObsFold
+	rdr.DecodeContent = s.cfg.DecodeRequestBodies
+	rdr.MaxDecodedBodyBytes = s.cfg.MaxDecodedBodyBytes
+	if s.cfg.PipelineDepth > 0 {
+		s.servePipelined(conn, state, rdr)
 		return
 	}
 
+	for first := true; ; first = false {
+		var w *response.Writer
+		keepAlive := false
+		sendContinue := func() error {
+			conn.SetWriteDeadline(deadline(s.cfg.WriteTimeout))
+			err := response.NewWriter(conn).WriteInformational(response.Continue, nil)
+			if err == nil && w != nil && w.StatusCode() == 0 {
+				// a streaming handler wants the body after all, so
+				// the connection stays in sync
+				w.SetKeepAlive(keepAlive)
+			}
+
+			return err
+		}
+		req, err := s.readRequest(
+			conn,
+			state,
+			rdr,
+			first,
+			s.cfg.StreamBodies,
+			sendContinue,
+		)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				conn.SetWriteDeadline(deadline(s.cfg.WriteTimeout))
+				writeError(response.NewWriter(conn), err)
+				lingeringClose(conn)
+			}
+
+			return
+		}
+
+		conn.SetWriteDeadline(deadline(s.cfg.WriteTimeout))
+		w = response.NewWriter(conn)
+		w.SetVersion(req.RequestLine.HttpVersion)
+		w.SetMethod(req.RequestLine.Method)
+		keepAlive = req.KeepAlive() && !s.closed.Load()
+		// a client still waiting for 100 Continue may never send the
+		// body, so the connection is closed unless the handler reads it
+		w.SetKeepAlive(keepAlive && !req.ContinuePending())
+		s.runHandler(w, req)
+		err = w.Finish()
+		state.inFlight.Add(-1)
+		if err != nil {
+			fmt.Printf("error finishing response: %s\n", err.Error())
+			return
+		}
+
+		if !w.KeepAlive() {
+			return
+		}
+
+		if err := req.DiscardBody(maxDrainBytes); err != nil {
+			if !errors.Is(err, request.ErrBodyNotSent) {
+				fmt.Printf("error discarding request body: %s\n", err.Error())
+			}
+
+			return
+		}
+	}
+}
+
+// runHandler calls the handler and recovers from panics in it. A panic is
+// answered with 500 Internal Server Error if the response has not been
+// started yet, the connection is closed in any case.
+func (s *Server) runHandler(w *response.Writer, req *request.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Printf(
+				"panic serving %s %s: %v\n%s",
+				req.RequestLine.Method,
+				req.RequestLine.RequestTarget,
+				err,
+				debug.Stack(),
+			)
+
+			w.Fail()
+		}
+	}()
+
 	s.handler(w, req)
 }
+
+// readRequest reads the next request from conn while enforcing the
+// configured timeouts. The body is buffered unless stream is set, in which
+// case the body timeout keeps running while the handler reads it. Clients
+// expecting 100 Continue are sent it through sendContinue once the body is
+// read. A connection that stays idle for too long or is closed before
+// sending anything is reported as io.EOF.
+func (s *Server) readRequest(
+	conn net.Conn,
+	state *connState,
+	rdr *request.Reader,
+	first, stream bool,
+	sendContinue func() error,
+) (*request.Request, error) {
+	wait := s.cfg.ReadHeaderTimeout
+	if !first && s.cfg.IdleTimeout > 0 {
+		wait = s.cfg.IdleTimeout
+	}
+
+	conn.SetReadDeadline(deadline(wait))
+	state.waiting.Store(true)
+	err := rdr.WaitForRequest()
+	state.waiting.Store(false)
+	if err != nil {
+		// nothing has been received, so there is nobody to answer
+		return nil, io.EOF
+	}
+
+	state.inFlight.Add(1)
+
+	conn.SetReadDeadline(deadline(s.cfg.ReadHeaderTimeout))
+	req, err := rdr.ReadStreamingRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	req.OnContinue(sendContinue)
+	conn.SetReadDeadline(deadline(s.cfg.ReadBodyTimeout))
+	if !stream {
+		if err := req.ReadBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	return req, nil
+}
+
+type pipelinedResponse struct {
+	buf  bytes.Buffer
+	w    *response.Writer
+	done chan struct{}
+	// interim is set for a 1xx response preceding the final one
+	interim bool
+}
+
+// servePipelined keeps reading requests while their handlers run and writes
+// the buffered responses back strictly in request order.
+func (s *Server) servePipelined(
+	conn net.Conn,
+	state *connState,
+	rdr *request.Reader,
+) {
+	queue := make(chan *pipelinedResponse, s.cfg.PipelineDepth)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// 100 Continue has to wait for the responses to earlier requests, so it
+	// is queued like them
+	sendContinue := func() error {
+		res := &pipelinedResponse{done: make(chan struct{}), interim: true}
+		res.w = response.NewWriter(&res.buf)
+		close(res.done)
+		if err := res.w.WriteInformational(response.Continue, nil); err != nil {
+			return err
+		}
+
+		select {
+		case queue <- res:
+			return nil
+		case <-stop:
+			return net.ErrClosed
+		}
+	}
+
+	go func() {
+		defer close(queue)
+		for first := true; ; first = false {
+			res := &pipelinedResponse{done: make(chan struct{})}
+			res.w = response.NewWriter(&res.buf)
+
+			req, err := s.readRequest(conn, state, rdr, first, false, sendContinue)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return
+				}
+
+				// answer in turn, after the requests that parsed fine
+				writeError(res.w, err)
+				close(res.done)
+			} else {
+				res.w.SetVersion(req.RequestLine.HttpVersion)
+				res.w.SetMethod(req.RequestLine.Method)
+				res.w.SetKeepAlive(req.KeepAlive() && !s.closed.Load())
+				go func() {
+					defer close(res.done)
+					s.runHandler(res.w, req)
+					if err := res.w.Finish(); err != nil {
+						fmt.Printf("error finishing response: %s\n", err.Error())
+					}
+				}()
+			}
+
+			select {
+			case queue <- res:
+			case <-stop:
+				return
+			}
+
+			if err != nil || !req.KeepAlive() {
+				return
+			}
+		}
+	}()
+
+	for res := range queue {
+		<-res.done
+		conn.SetWriteDeadline(deadline(s.cfg.WriteTimeout))
+		_, err := conn.Write(res.buf.Bytes())
+		if !res.interim {
+			state.inFlight.Add(-1)
+		}
+
+		if err != nil {
+			fmt.Printf("error writing response: %s\n", err.Error())
+			return
+		}
+
+		if !res.w.KeepAlive() {
+			return
+		}
+	}
+}
+
+// writeError answers a request that could not be read and closes the
+// connection afterwards.
+func writeError(w *response.Writer, err error) {
+	statusCode := response.BadRequest
+	h := response.GetDefaultHeaders(0)
+	switch {
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		statusCode = response.RequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrBodyTooLarge):
+		statusCode = response.ContentTooLarge
+	case errors.Is(err, request.ErrUnsupportedTransferCoding):
+		statusCode = response.NotImplemented
+	case errors.Is(err, request.ErrUnsupportedContentCoding):
+		statusCode = response.UnsupportedMediaType
+		// tells the client which codings it can use instead
+		h.Set("Accept-Encoding", "gzip, deflate")
+	case errors.Is(err, request.ErrExpectationFailed):
+		statusCode = response.ExpectationFailed
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		statusCode = response.HTTPVersionNotSupported
+	case isTimeout(err):
+		statusCode = response.RequestTimeout
+	}
+
+	w.SetKeepAlive(false)
+	w.WriteStatusLine(statusCode)
+	body := fmt.Appendf(nil, "error parsing request: %v", err)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+// lingeringClose shuts down the sending side of conn and skims off what the
+// client is still sending, so that an early error response is not wiped out
+// by a TCP reset for the unread request bytes.
+func lingeringClose(conn net.Conn) {
+	// both *net.TCPConn and *tls.Conn can half-close
+	halfCloser, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
+		return
+	}
+
+	halfCloser.CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+	io.Copy(io.Discard, io.LimitReader(conn, maxDrainBytes))
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// deadline turns a timeout into a deadline, zero meaning none.
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(timeout)
+}
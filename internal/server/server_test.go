@@ -0,0 +1,511 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nordluma/httpfromtcp/internal/request"
+	"github.com/nordluma/httpfromtcp/internal/response"
+)
+
+func echoTargetHandler(w *response.Writer, req *request.Request) {
+	// make earlier requests finish last to shake out ordering bugs
+	switch req.RequestLine.RequestTarget {
+	case "/1":
+		time.Sleep(60 * time.Millisecond)
+	case "/2":
+		time.Sleep(30 * time.Millisecond)
+	}
+
+	body := []byte(req.RequestLine.RequestTarget)
+	w.WriteStatusLine(response.Ok)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func startServer(t *testing.T, handler Handler, opts ...Option) net.Conn {
+	t.Helper()
+	s, err := Serve(0, handler, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	return conn
+}
+
+func TestKeepAliveServesSeveralRequests(t *testing.T) {
+	conn := startServer(t, echoTargetHandler)
+
+	_, err := io.WriteString(conn, "GET /1 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	_, err = io.WriteString(
+		conn,
+		"GET /2 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+	)
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(out), "HTTP/1.1 200 OK"))
+	assert.True(t, strings.HasSuffix(string(out), "/2"))
+	assert.Contains(t, string(out), "Connection: close")
+}
+
+func TestPipelinedResponsesKeepRequestOrder(t *testing.T) {
+	conn := startServer(t, echoTargetHandler, WithPipelining(4))
+
+	_, err := io.WriteString(conn,
+		"GET /1 HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /2 HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /3 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+	)
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	first := strings.Index(string(out), "\r\n\r\n/1")
+	second := strings.Index(string(out), "\r\n\r\n/2")
+	third := strings.Index(string(out), "\r\n\r\n/3")
+	require.NotEqual(t, -1, first)
+	assert.Less(t, first, second)
+	assert.Less(t, second, third)
+}
+
+func TestStreamingBodyIsDrainedBeforeNextRequest(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		// read only the first byte, the server has to skip the rest
+		buf := make([]byte, 1)
+		req.BodyReader().Read(buf)
+		body := append(buf, req.RequestLine.RequestTarget...)
+		w.WriteStatusLine(response.Ok)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}, WithStreamingBodies())
+
+	_, err := io.WriteString(conn,
+		"POST /1 HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello"+
+			"POST /2 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n"+
+			"Content-Length: 5\r\n\r\nworld",
+	)
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "\r\n\r\nh/1")
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nw/2"))
+}
+
+func TestLimitsAndTimeoutsAreAnswered(t *testing.T) {
+	cases := []struct {
+		name    string
+		cfg     Config
+		request string
+		status  string
+	}{
+		{
+			name:    "header too large",
+			cfg:     Config{MaxHeaderBytes: 64},
+			request: "GET / HTTP/1.1\r\nHost: localhost\r\nX-Filler: " + strings.Repeat("a", 100),
+			status:  "HTTP/1.1 431 Request Header Fields Too Large",
+		},
+		{
+			name:    "body too large",
+			cfg:     Config{MaxBodyBytes: 4},
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\n",
+			status:  "HTTP/1.1 413 Content Too Large",
+		},
+		{
+			name:    "slow headers",
+			cfg:     Config{ReadHeaderTimeout: 50 * time.Millisecond},
+			request: "GET / HTTP/1.1\r\nHost: loc",
+			status:  "HTTP/1.1 408 Request Timeout",
+		},
+		{
+			name:    "unknown transfer coding",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip, chunked\r\n\r\n",
+			status:  "HTTP/1.1 501 Not Implemented",
+		},
+		{
+			name: "transfer-encoding and content-length",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n" +
+				"Content-Length: 3\r\n\r\n0\r\n\r\n",
+			status: "HTTP/1.1 400 Bad Request",
+		},
+		{
+			name:    "invalid target",
+			request: "GET /%zz HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			status:  "HTTP/1.1 400 Bad Request",
+		},
+		{
+			name:    "unsupported version",
+			request: "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n",
+			status:  "HTTP/1.1 505 HTTP Version Not Supported",
+		},
+		{
+			name:    "missing host",
+			request: "GET / HTTP/1.1\r\n\r\n",
+			status:  "HTTP/1.1 400 Bad Request",
+		},
+		{
+			name:    "unsupported expectation",
+			request: "GET / HTTP/1.1\r\nHost: localhost\r\nExpect: teapot\r\n\r\n",
+			status:  "HTTP/1.1 417 Expectation Failed",
+		},
+		{
+			name: "unsupported content coding",
+			cfg:  Config{DecodeRequestBodies: true},
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\n" +
+				"Content-Encoding: br\r\nContent-Length: 1\r\n\r\nx",
+			status: "HTTP/1.1 415 Unsupported Media Type",
+		},
+		{
+			name: "decoded body too large",
+			cfg:  Config{DecodeRequestBodies: true, MaxDecodedBodyBytes: 4},
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\n" +
+				"Content-Encoding: gzip\r\nContent-Length: " +
+				strconv.Itoa(len(gzipHello)) + "\r\n\r\n" + gzipHello,
+			status: "HTTP/1.1 413 Content Too Large",
+		},
+		{
+			name:    "folded header",
+			request: "GET / HTTP/1.1\r\nHost: localhost\r\nX-Test: a\r\n b\r\n\r\n",
+			status:  "HTTP/1.1 400 Bad Request",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := startServer(t, echoTargetHandler, WithConfig(c.cfg))
+			_, err := io.WriteString(conn, c.request)
+			require.NoError(t, err)
+
+			out, err := io.ReadAll(conn)
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(string(out), c.status), string(out))
+		})
+	}
+}
+
+func TestIdleConnectionIsClosedSilently(t *testing.T) {
+	conn := startServer(t, echoTargetHandler, WithConfig(Config{
+		IdleTimeout: 50 * time.Millisecond,
+	}))
+
+	_, err := io.WriteString(conn, "GET /3 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\n/3"))
+}
+
+func TestShutdownWaitsForActiveConnections(t *testing.T) {
+	started := make(chan struct{})
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		echoTargetHandler(w, req)
+	})
+	require.NoError(t, err)
+
+	idle, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer idle.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /busy HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	forced, err := s.Shutdown(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, forced)
+
+	// the in-flight response is completed and the connection closed
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(out), "/busy"))
+}
+
+func TestShutdownCutsOffStragglers(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	})
+	require.NoError(t, err)
+	defer close(release)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	forced, err := s.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, forced)
+}
+
+func TestHandlerPanicIsRecovered(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/panic" {
+			panic("boom")
+		}
+
+		echoTargetHandler(w, req)
+	}
+	s, err := Serve(0, handler)
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, "GET /panic HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 500 Internal Server Error"))
+	assert.Contains(t, string(out), "Connection: close")
+
+	// the server keeps serving other connections
+	conn, err = net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(
+		conn,
+		"GET /3 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+	)
+	require.NoError(t, err)
+
+	out, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK"))
+}
+
+func TestLegacyVersionsAreAnswered(t *testing.T) {
+	conn := startServer(t, echoTargetHandler)
+	_, err := io.WriteString(conn, "GET /3 HTTP/1.0\r\n\r\n")
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.0 200 OK\r\n"), string(out))
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\n/3"))
+
+	conn = startServer(t, echoTargetHandler)
+	_, err = io.WriteString(conn, "GET /3\r\n")
+	require.NoError(t, err)
+
+	out, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "/3", string(out))
+}
+
+func TestExpectContinueIsAnswered(t *testing.T) {
+	bodyHandler := func(w *response.Writer, req *request.Request) {
+		body, _ := io.ReadAll(req.BodyReader())
+		w.WriteStatusLine(response.Ok)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+
+	cases := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "buffered"},
+		{name: "streaming", opts: []Option{WithStreamingBodies()}},
+		{name: "pipelined", opts: []Option{WithPipelining(4)}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := startServer(t, bodyHandler, c.opts...)
+			_, err := io.WriteString(conn, "POST / HTTP/1.1\r\nHost: localhost\r\n"+
+				"Expect: 100-continue\r\nContent-Length: 5\r\nConnection: close\r\n\r\n")
+			require.NoError(t, err)
+
+			interim := "HTTP/1.1 100 Continue\r\n\r\n"
+			buf := make([]byte, len(interim))
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t, err)
+			assert.Equal(t, interim, string(buf))
+
+			_, err = io.WriteString(conn, "hello")
+			require.NoError(t, err)
+			out, err := io.ReadAll(conn)
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"), string(out))
+			assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nhello"))
+		})
+	}
+}
+
+func TestExpectContinueRejectedByHandler(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.ContentTooLarge)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	}, WithStreamingBodies())
+
+	_, err := io.WriteString(conn, "POST / HTTP/1.1\r\nHost: localhost\r\n"+
+		"Expect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	require.NoError(t, err)
+
+	// the body is never sent and the connection is closed after the answer
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 413 Content Too Large\r\n"), string(out))
+	assert.NotContains(t, string(out), "100 Continue")
+	assert.Contains(t, string(out), "Connection: close\r\n")
+}
+
+// gzipHello is "hello" compressed with gzip.
+var gzipHello = func() string {
+	var buf bytes.Buffer
+	enc := gzip.NewWriter(&buf)
+	enc.Write([]byte("hello"))
+	enc.Close()
+
+	return buf.String()
+}()
+
+func TestDecodedRequestBodies(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.Write(req.Body)
+	}, WithConfig(Config{DecodeRequestBodies: true}))
+
+	_, err := io.WriteString(conn, "POST / HTTP/1.1\r\nHost: localhost\r\n"+
+		"Content-Encoding: gzip\r\nConnection: close\r\nContent-Length: "+
+		strconv.Itoa(len(gzipHello))+"\r\n\r\n"+gzipHello)
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK"))
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nhello"))
+}
+
+func TestHeadLeavesConnectionInSync(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.Write([]byte("hello body"))
+	})
+
+	_, err := io.WriteString(conn, "HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	head, get, found := strings.Cut(string(out), "\r\n\r\n")
+	require.True(t, found, string(out))
+	assert.Contains(t, head, "Content-Length: 10")
+	assert.True(t, strings.HasPrefix(get, "HTTP/1.1 200 OK\r\n"), get)
+	assert.True(t, strings.HasSuffix(get, "\r\n\r\nhello body"), get)
+}
+
+func TestBytesAfterEncodedBodyAreRejected(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.Write([]byte(req.RequestLine.RequestTarget))
+	}, WithConfig(Config{DecodeRequestBodies: true}))
+
+	var buf bytes.Buffer
+	enc := zlib.NewWriter(&buf)
+	enc.Write([]byte("{}"))
+	enc.Close()
+	smuggled := "GET /admin HTTP/1.1\r\nHost: x\r\n\r\n"
+
+	_, err := io.WriteString(conn, "POST /upload HTTP/1.1\r\nHost: localhost\r\n"+
+		"Content-Encoding: deflate\r\nContent-Length: "+
+		strconv.Itoa(buf.Len()+len(smuggled))+"\r\n\r\n"+buf.String())
+	require.NoError(t, err)
+	_, err = io.WriteString(conn, smuggled)
+	require.NoError(t, err)
+
+	// the smuggled request is never served
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 400 Bad Request\r\n"), string(out))
+	assert.Equal(t, 1, strings.Count(string(out), "HTTP/1.1"), string(out))
+	assert.NotContains(t, string(out), "/admin")
+}
+
+func TestEmptyEncodedBodyIsRejected(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.Write(req.Body)
+	}, WithConfig(Config{DecodeRequestBodies: true}))
+
+	_, err := io.WriteString(conn, "POST / HTTP/1.1\r\nHost: localhost\r\n"+
+		"Content-Encoding: gzip\r\nContent-Length: 0\r\n\r\n")
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 400 Bad Request\r\n"), string(out))
+}
+
+func TestBodyForNoContentIsNotSent(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/1" {
+			w.WriteStatusLine(response.NoContent)
+		}
+		w.Write([]byte(req.RequestLine.RequestTarget))
+	})
+
+	_, err := io.WriteString(conn,
+		"GET /1 HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /2 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+	)
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out),
+		"HTTP/1.1 204 No Content\r\n\r\nHTTP/1.1 200 OK\r\n",
+	))
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\n/2"))
+}
+
+func TestAutoFramedResponsesKeepConnectionAlive(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.Write([]byte(req.RequestLine.RequestTarget))
+	})
+
+	_, err := io.WriteString(conn,
+		"GET /1 HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /2 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+	)
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(out), "Content-Length: 2\r\n"))
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\n/2"))
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+// ServeTLS is like Serve but terminates TLS with the PEM encoded certificate
+// and key read from certFile and keyFile.
+func ServeTLS(
+	port int,
+	certFile, keyFile string,
+	handler Handler,
+	opts ...Option,
+) (*Server, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	opts = append(opts, WithTLSConfig(tlsConfig))
+
+	return Serve(port, handler, opts...)
+}
+
+// WithTLSConfig makes the server accept TLS connections only, using
+// tlsConfig for the handshakes.
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(s *Server) {
+		s.cfg.TLSConfig = tlsConfig
+	}
+}
+
+// SNICertificates picks the certificate for a TLS handshake by the server name
+// the client asked for. Names may start with a "*." wildcard label matching a
+// single subdomain level. Use its GetCertificate method in a tls.Config.
+type SNICertificates struct {
+	certs map[string]*tls.Certificate
+	// Default is used when no name matches or the client did not send
+	// one.
+	Default *tls.Certificate
+}
+
+func NewSNICertificates() *SNICertificates {
+	return &SNICertificates{
+		certs: make(map[string]*tls.Certificate),
+	}
+}
+
+// Add registers cert for name, e.g. "example.com" or "*.example.com". The
+// first certificate added becomes the default unless one is set.
+func (c *SNICertificates) Add(name string, cert tls.Certificate) {
+	c.certs[strings.ToLower(name)] = &cert
+	if c.Default == nil {
+		c.Default = &cert
+	}
+}
+
+func (c *SNICertificates) GetCertificate(
+	hello *tls.ClientHelloInfo,
+) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, found := c.certs[name]; found {
+		return cert, nil
+	}
+
+	if _, parent, found := strings.Cut(name, "."); found {
+		if cert, found := c.certs["*."+parent]; found {
+			return cert, nil
+		}
+	}
+
+	if c.Default == nil {
+		return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+	}
+
+	return c.Default, nil
+}
+
+// GenerateSelfSignedCert creates a self-signed certificate valid for a year
+// for the given host names and IP addresses. It is meant for local testing,
+// clients will not trust it without being told to.
+func GenerateSelfSignedCert(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"httpfromtcp"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeOverTLS(t *testing.T) {
+	cert, err := GenerateSelfSignedCert("localhost", "127.0.0.1")
+	require.NoError(t, err)
+
+	s, err := Serve(0, echoTargetHandler, WithTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{
+		RootCAs:    roots,
+		ServerName: "localhost",
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = io.WriteString(
+		conn,
+		"GET /3 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+	)
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK"))
+	assert.True(t, strings.HasSuffix(string(out), "/3"))
+}
+
+func TestSNICertificatesSelection(t *testing.T) {
+	apiCert, err := GenerateSelfSignedCert("api.example.com")
+	require.NoError(t, err)
+	wildcardCert, err := GenerateSelfSignedCert("*.example.com")
+	require.NoError(t, err)
+	fallbackCert, err := GenerateSelfSignedCert("localhost")
+	require.NoError(t, err)
+
+	sni := NewSNICertificates()
+	sni.Add("localhost", fallbackCert)
+	sni.Add("api.example.com", apiCert)
+	sni.Add("*.example.com", wildcardCert)
+
+	cases := []struct {
+		serverName string
+		want       tls.Certificate
+	}{
+		{serverName: "api.example.com", want: apiCert},
+		{serverName: "API.example.com.", want: apiCert},
+		{serverName: "www.example.com", want: wildcardCert},
+		{serverName: "a.b.example.com", want: fallbackCert},
+		{serverName: "", want: fallbackCert},
+	}
+
+	for _, c := range cases {
+		got, err := sni.GetCertificate(&tls.ClientHelloInfo{ServerName: c.serverName})
+		require.NoError(t, err)
+		assert.Equal(t, c.want.Leaf, got.Leaf, c.serverName)
+	}
+}
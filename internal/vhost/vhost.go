@@ -0,0 +1,84 @@
+package vhost
+
+import (
+	"net"
+	"strings"
+
+	"github.com/nordluma/httpfromtcp/internal/request"
+	"github.com/nordluma/httpfromtcp/internal/response"
+	"github.com/nordluma/httpfromtcp/internal/server"
+)
+
+// Dispatcher selects a handler by the host a request was sent to, so that a
+// single server can serve several sites. Hosts are matched
+// case-insensitively and without the port.
+type Dispatcher struct {
+	hosts map[string]server.Handler
+
+	// Default is called when no host matches. It answers with 421
+	// Misdirected Request unless replaced.
+	Default server.Handler
+}
+
+func New() *Dispatcher {
+	return &Dispatcher{
+		hosts:   make(map[string]server.Handler),
+		Default: misdirected,
+	}
+}
+
+// Handle registers handler for host, e.g. "example.com" or "*.example.com".
+// A wildcard matches subdomains at any depth but not the domain itself, and
+// the longest matching wildcard wins.
+func (d *Dispatcher) Handle(host string, handler server.Handler) {
+	d.hosts[normalizeHost(host)] = handler
+}
+
+// Serve is a server.Handler dispatching to the handler registered for the
+// request host.
+func (d *Dispatcher) Serve(w *response.Writer, req *request.Request) {
+	d.handler(req.Host())(w, req)
+}
+
+func (d *Dispatcher) handler(host string) server.Handler {
+	name := normalizeHost(host)
+	if name == "" {
+		return d.Default
+	}
+
+	if handler, found := d.hosts[name]; found {
+		return handler
+	}
+
+	for {
+		_, parent, found := strings.Cut(name, ".")
+		if !found {
+			return d.Default
+		}
+
+		if handler, found := d.hosts["*."+parent]; found {
+			return handler
+		}
+
+		name = parent
+	}
+}
+
+// normalizeHost strips the port and a trailing dot from host and lowercases
+// it.
+func normalizeHost(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+
+	host = strings.Trim(host, "[]")
+
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func misdirected(w *response.Writer, _ *request.Request) {
+	w.WriteStatusLine(response.MisdirectedRequest)
+	body := []byte("421 Misdirected Request")
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
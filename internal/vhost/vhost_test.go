@@ -0,0 +1,87 @@
+package vhost
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nordluma/httpfromtcp/internal/request"
+	"github.com/nordluma/httpfromtcp/internal/response"
+	"github.com/nordluma/httpfromtcp/internal/server"
+)
+
+func namedHandler(name string) server.Handler {
+	return func(w *response.Writer, _ *request.Request) {
+		body := []byte(name)
+		w.WriteStatusLine(response.Ok)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+}
+
+func serve(t *testing.T, d *Dispatcher, target, host string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(bytes.NewBufferString(
+		fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, host),
+	))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	d.Serve(response.NewWriter(&buf), req)
+
+	return buf.String()
+}
+
+func TestDispatcherSelectsHandlerByHost(t *testing.T) {
+	d := New()
+	d.Handle("example.com", namedHandler("apex"))
+	d.Handle("*.example.com", namedHandler("wildcard"))
+	d.Handle("*.api.example.com", namedHandler("api"))
+	d.Handle("Docs.Example.com", namedHandler("docs"))
+	d.Handle("::1", namedHandler("ipv6"))
+
+	cases := []struct {
+		host string
+		want string
+	}{
+		{host: "example.com", want: "apex"},
+		{host: "EXAMPLE.com:8080", want: "apex"},
+		{host: "example.com.", want: "apex"},
+		{host: "docs.example.com", want: "docs"},
+		{host: "www.example.com", want: "wildcard"},
+		{host: "a.b.example.com", want: "wildcard"},
+		{host: "v1.api.example.com", want: "api"},
+		{host: "api.example.com", want: "wildcard"},
+		{host: "[::1]:42069", want: "ipv6"},
+	}
+
+	for _, c := range cases {
+		out := serve(t, d, "/", c.host)
+		assert.Contains(t, out, "HTTP/1.1 200 OK", c.host)
+		assert.Contains(t, out, "\r\n\r\n"+c.want, c.host)
+	}
+}
+
+func TestDispatcherFallsBackToDefault(t *testing.T) {
+	d := New()
+	d.Handle("example.com", namedHandler("apex"))
+
+	out := serve(t, d, "/", "example.org")
+	assert.Contains(t, out, "HTTP/1.1 421 Misdirected Request")
+
+	d.Default = namedHandler("default")
+	assert.Contains(t, serve(t, d, "/", "example.org"), "\r\n\r\ndefault")
+	assert.Contains(t, serve(t, d, "/", "com"), "\r\n\r\ndefault")
+}
+
+func TestDispatcherUsesAbsoluteFormTarget(t *testing.T) {
+	d := New()
+	d.Handle("example.com", namedHandler("apex"))
+	d.Handle("other.example", namedHandler("other"))
+
+	out := serve(t, d, "http://other.example/", "example.com")
+	assert.Contains(t, out, "\r\n\r\nother")
+}